@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes a single admission decision.
+type Record struct {
+	Time        time.Time `json:"time"`
+	UID         string    `json:"uid"`
+	RequestID   string    `json:"requestID,omitempty"`
+	Cluster     string    `json:"cluster,omitempty"`
+	User        string    `json:"user"`
+	Groups      []string  `json:"groups"`
+	Operation   string    `json:"operation"`
+	Kind        string    `json:"kind"`
+	Resource    string    `json:"resource"`
+	SubResource string    `json:"subresource,omitempty"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Decision    string    `json:"decision"`
+	Team        string    `json:"team,omitempty"`
+	Reason      string    `json:"reason"`
+	Code        string    `json:"code,omitempty"`
+	LastManager string    `json:"lastManager,omitempty"`
+	Latency     float64   `json:"latencySeconds"`
+}
+
+// Logger writes audit records as JSON lines, independently of the operational log.
+type Logger struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// New returns a logger writing to the specified file, or to standard output if the path is '-'.
+// The file is rotated when it would grow beyond maxSize bytes, keeping up to maxBackups old files.
+func New(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	if path == "-" {
+		return &Logger{writer: os.Stdout}, nil
+	}
+	file, err := openRotating(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("while opening audit log: %s", err)
+	}
+	return &Logger{writer: file}, nil
+}
+
+// Log writes a record. Records are never split across rotated files.
+func (l *Logger) Log(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.writer.Write(data)
+	return err
+}
@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := New(path, 200, 2)
+	assert.NoError(t, err)
+
+	for _, user := range []string{"first", "second", "third", "fourth"} {
+		assert.NoError(t, logger.Log(Record{User: user, Decision: "allowed"}))
+	}
+
+	// Records are too large to share a file, so every record starts a new file.
+	for file, user := range map[string]string{path: "fourth", path + ".1": "third", path + ".2": "second"} {
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(data), "\n"))
+		assert.Contains(t, string(data), `"user":"`+user+`"`)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	assert.NoError(t, err)
+	assert.Len(t, files, 3, "only the configured number of backups is kept")
+}
+
+func TestStream(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The stream owns the write end, and closes it when done.
+	stream := newStream(w)
+	go stream.Run(ctx)
+	assert.True(t, stream.Record(Record{User: "first", Decision: "allowed"}))
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, line, `"user":"first"`)
+
+	_, err = Stream("fd:1")
+	assert.Error(t, err)
+	_, err = Stream(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestStreamDropsWhenFull(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	// Nothing drains the queue, as if the reader had stopped reading.
+	stream := newStream(w)
+	for i := 0; i < streamQueueSize; i++ {
+		assert.True(t, stream.Record(Record{User: "queued"}))
+	}
+	assert.False(t, stream.Record(Record{User: "dropped"}))
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotatingFile is a file that is renamed to path.1 when it reaches its maximum size, shifting
+// older files to path.2, path.3 and so on, until the oldest file is removed.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotating(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func backup(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+func (r *rotatingFile) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if r.maxBackups > 0 {
+		os.Remove(backup(r.path, r.maxBackups))
+		for n := r.maxBackups - 1; n > 0; n-- {
+			os.Rename(backup(r.path, n), backup(r.path, n+1))
+		}
+		err = os.Rename(r.path, backup(r.path, 1))
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+// Write is not safe for concurrent use.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("while rotating audit log: %s", err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of records waiting to be written to a decision stream before further records are dropped.
+const streamQueueSize = 1000
+
+// StreamLogger writes audit records to a decision stream in the background,
+// so that a slow reader never holds up admission requests.
+type StreamLogger struct {
+	logger *Logger
+	file   *os.File
+	queue  chan Record
+}
+
+// Stream returns a logger writing to an inherited file descriptor given as 'fd:N', or to a named pipe.
+// Streams are never rotated. Opening a named pipe blocks until a reader has opened it.
+func Stream(target string) (*StreamLogger, error) {
+	if strings.HasPrefix(target, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 3 {
+			return nil, fmt.Errorf("'%s' is not a valid file descriptor; use fd:N with N >= 3", target)
+		}
+		return newStream(os.NewFile(uintptr(fd), target)), nil
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("while opening decision stream: %s", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("decision stream '%s' is not a named pipe", target)
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("while opening decision stream: %s", err)
+	}
+	return newStream(file), nil
+}
+
+func newStream(file *os.File) *StreamLogger {
+	return &StreamLogger{
+		logger: &Logger{writer: file},
+		file:   file,
+		queue:  make(chan Record, streamQueueSize),
+	}
+}
+
+// Record queues a record for writing, without waiting for the reader.
+// Returns false if the queue is full and the record was dropped.
+func (s *StreamLogger) Record(record Record) bool {
+	select {
+	case s.queue <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run writes queued records until the context is done, and then closes the stream.
+func (s *StreamLogger) Run(ctx context.Context) {
+	defer s.file.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-s.queue:
+			err := s.logger.Log(record)
+			if err != nil {
+				log.Errorf("while writing decision stream: %s", err)
+			}
+		}
+	}
+}
@@ -2,61 +2,117 @@ package azure
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/oauth2/clientcredentials"
-	"golang.org/x/oauth2/microsoft"
+	"golang.org/x/oauth2"
 )
 
-var (
-	clientID                    = os.Getenv("AZURE_APP_ID")
-	clientSecret                = os.Getenv("AZURE_PASSWORD")
-	tenantID                    = os.Getenv("AZURE_TENANT")
-	teamMembershipApplicationID = os.Getenv("AZURE_TEAM_MEMBERSHIP_APP_ID")
-)
+// Application whose assigned groups are considered teams.
+var teamMembershipApplicationID string
+
+// SetTeamMembershipApplication configures the ID of the application whose assigned groups are considered teams.
+func SetTeamMembershipApplication(id string) {
+	teamMembershipApplicationID = id
+}
 
 type Team struct {
 	AzureUUID   string
 	ID          string
 	Title       string
 	Description string
+	Contact     string // Where to request access to the team, e.g. a Slack channel or mail address
+	DeniedKinds []string
+	Aliases     []string
+	Groups      []string  // Additional groups whose members belong to the team
+	Members     []string  // Usernames belonging to the team regardless of group membership
+	Deleted     time.Time // When the team disappeared from the team provider, if it is kept during a grace period
 }
 
-// Valid returns true if the ID fields are non-empty.
+// Valid returns true if the ID field is non-empty, and team membership can be determined.
 func (team Team) Valid() bool {
-	return len(team.AzureUUID) > 0 && len(team.ID) > 0
+	return len(team.ID) > 0 && (len(team.AzureUUID) > 0 || len(team.Groups) > 0 || len(team.Members) > 0)
 }
 
-func client(ctx context.Context) *http.Client {
-	config := clientcredentials.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		Scopes:       []string{"https://graph.microsoft.com/.default"},
-		TokenURL:     microsoft.AzureADEndpoint(tenantID).TokenURL,
+// Base HTTP client for Microsoft Graph and token requests. Nil uses the default client.
+var httpClient *http.Client
+
+// SetProxy routes all Microsoft Graph and token requests through the specified proxy, overriding proxy
+// environment variables. Proxy credentials may be given as user info in the URL. If caFile is set, the
+// certificates in that file are trusted in addition to the system roots, e.g. for proxies intercepting TLS.
+func SetProxy(proxy, caFile string) error {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("while parsing proxy URL: %s", err)
 	}
 
-	return config.Client(ctx)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	if len(caFile) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("while loading system certificates: %s", err)
+		}
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("while reading proxy CA bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in proxy CA bundle '%s'", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	httpClient = &http.Client{Transport: transport}
+	resetTokenCache()
+	return nil
+}
+
+func client(ctx context.Context) *http.Client {
+	if httpClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+	return oauth2.NewClient(ctx, tokens())
 }
 
 // Teams retrieves the canonical list of team groups from the Microsoft Graph API.
 func Teams(ctx context.Context) (map[string]Team, error) {
-	graphAPI := NewGraphAPI(client(ctx))
+	graphAPI := NewGraphAPI(ctx, client(ctx))
 
 	teamGroups, err := graphAPI.GroupsFromApplication(teamMembershipApplicationID)
 	if err != nil {
 		return nil, err
 	}
 
+	return teamsFromGroups(teamGroups), nil
+}
+
+// Ping checks that a token can be acquired, and that the team membership application can be read
+// from the Microsoft Graph API.
+func Ping(ctx context.Context) error {
+	u := fmt.Sprintf("https://graph.microsoft.com/v1.0/servicePrincipals/%s?$select=id", url.PathEscape(teamMembershipApplicationID))
+	_, _, err := NewGraphAPI(ctx, client(ctx)).query(u)
+	return err
+}
+
+func teamsFromGroups(teamGroups []Group) map[string]Team {
 	teams := make(map[string]Team)
 	for _, teamGroup := range teamGroups {
 		team := Team{
-			AzureUUID: teamGroup.ID,
-			Title:     teamGroup.DisplayName,
-			ID:        strings.ToLower(teamGroup.MailNickname),
+			AzureUUID:   teamGroup.ID,
+			Title:       teamGroup.DisplayName,
+			Description: teamGroup.Description,
+			Contact:     teamGroup.Mail,
+			ID:          strings.ToLower(teamGroup.MailNickname),
 		}
 		if team.Valid() {
 			teams[team.ID] = team
@@ -68,10 +124,231 @@ func Teams(ctx context.Context) (map[string]Team, error) {
 			log.Warnf("azure: transposing real team name '%s' to lowercase '%s'", teamGroup.MailNickname, team.ID)
 		}
 	}
+	return teams
+}
+
+// Provider retrieves team groups incrementally. Groups assigned to the team membership application
+// are listed on every sync, but group details are only retrieved for newly assigned groups, and for
+// groups reported as changed by a Microsoft Graph delta query.
+type Provider struct {
+	options   Options
+	groups    map[string]Group
+	deltaLink string
+	// Number of groups assigned to the application at the last accepted sync.
+	assigned int
+	// Number of consecutive syncs rejected as truncated.
+	truncated int
+}
+
+// A sync returning less than this fraction of the previously assigned groups is considered truncated.
+const truncationRatio = 0.5
+
+// A seemingly truncated group list is accepted when it is returned by this many consecutive syncs.
+const truncationAttempts = 3
+
+type Options struct {
+	// Use delta queries to only retrieve details for new and changed groups.
+	Incremental bool
+	// Include nested groups and their users in team membership.
+	TransitiveMembers bool
+	// Maximum number of concurrent group queries.
+	Concurrency int
+	// Only import groups whose mail nickname matches this expression. Nil imports all groups.
+	GroupFilter *regexp.Regexp
+	// Receiver of group change notifications, if subscribed.
+	Notifications *Notifications
+}
+
+func NewProvider(options Options) *Provider {
+	return &Provider{
+		options: options,
+		groups:  make(map[string]Group),
+	}
+}
+
+// Discard all cached state, forcing the next sync to retrieve every group.
+func (p *Provider) reset() {
+	p.groups = make(map[string]Group)
+	p.deltaLink = ""
+}
+
+// Apply group changes since the previous sync to the group cache.
+func (p *Provider) applyDelta(graphAPI *GraphAPI) error {
+	if len(p.deltaLink) == 0 {
+		deltaLink, err := graphAPI.LatestGroupDeltaLink()
+		if err != nil {
+			return err
+		}
+		p.deltaLink = deltaLink
+		return nil
+	}
+
+	changes, deltaLink, err := graphAPI.GroupDelta(p.deltaLink)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		group, ok := p.groups[change.ID]
+		if !ok {
+			continue
+		}
+		if change.Removed != nil {
+			log.Debugf("azure: group '%s' was removed", change.ID)
+			delete(p.groups, change.ID)
+			continue
+		}
+		log.Debugf("azure: group '%s' changed", change.ID)
+		p.groups[change.ID] = change.Merge(group)
+	}
+	p.deltaLink = deltaLink
+
+	return nil
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]Team, error) {
+	graphAPI := NewGraphAPI(ctx, client(ctx))
+
+	// The delta link must be retrieved before group details, so that no changes are missed in between.
+	if p.options.Incremental {
+		err := p.applyDelta(graphAPI)
+		if err != nil {
+			log.Warnf("azure: delta query failed, retrieving all groups: %s", err)
+			p.reset()
+		}
+	} else {
+		p.reset()
+	}
+
+	groupIDs, err := graphAPI.GroupIDsFromApplication(teamMembershipApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.checkTruncation(len(groupIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := make(map[string]bool)
+	missing := make([]string, 0)
+	for _, groupID := range groupIDs {
+		assigned[groupID] = true
+		if _, ok := p.groups[groupID]; !ok {
+			missing = append(missing, groupID)
+		}
+	}
+
+	fetched, err := graphAPI.Groups(missing, p.options.Concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("recurse into groups: %s", err)
+	}
+	for _, group := range fetched {
+		p.groups[group.ID] = group
+	}
+
+	teamGroups := make([]Group, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group := p.groups[groupID]
+		if p.options.GroupFilter != nil && !p.options.GroupFilter.MatchString(group.MailNickname) {
+			log.Debugf("azure: skipping group '%s' not matching team group filter", group.MailNickname)
+			continue
+		}
+		teamGroups = append(teamGroups, group)
+	}
+
+	// Forget groups no longer assigned to the application.
+	for groupID := range p.groups {
+		if !assigned[groupID] {
+			delete(p.groups, groupID)
+		}
+	}
+
+	log.Debugf("azure: retrieved details for %d of %d groups", len(fetched), len(groupIDs))
+
+	if p.options.Notifications != nil {
+		p.options.Notifications.Watch(groupIDs)
+	}
+
+	teams := teamsFromGroups(teamGroups)
+
+	if p.options.TransitiveMembers {
+		for id, team := range teams {
+			team.Groups, team.Members, err = graphAPI.TransitiveMembers(team.AzureUUID)
+			if err != nil {
+				return nil, fmt.Errorf("transitive members of '%s': %s", id, err)
+			}
+			teams[id] = team
+			log.Debugf("azure: team '%s' has %d nested groups and %d members", id, len(team.Groups), len(team.Members))
+		}
+	}
 
 	return teams, nil
 }
 
+// Changes signals changes to team groups, if change notifications are enabled.
+func (p *Provider) Changes() <-chan struct{} {
+	if p.options.Notifications == nil {
+		return nil
+	}
+	return p.options.Notifications.Changes()
+}
+
+// Members lists the users in the team's group, including members of nested groups.
+func (p *Provider) Members(ctx context.Context, team Team) ([]string, error) {
+	if len(team.AzureUUID) == 0 {
+		return nil, nil
+	}
+	_, usernames, err := NewGraphAPI(ctx, client(ctx)).TransitiveMembers(team.AzureUUID)
+	return usernames, err
+}
+
+// Team retrieves a single team on demand, e.g. for a team created since the last sync.
+// Groups not assigned to the team membership application are not teams, and an invalid team is returned.
+func (p *Provider) Team(ctx context.Context, id string) (Team, error) {
+	graphAPI := NewGraphAPI(ctx, client(ctx))
+
+	group, err := graphAPI.GroupByMailNickname(id)
+	if err != nil || group == nil {
+		return Team{}, err
+	}
+	if p.options.GroupFilter != nil && !p.options.GroupFilter.MatchString(group.MailNickname) {
+		return Team{}, nil
+	}
+	assigned, err := graphAPI.AssignedToApplication(group.ID, teamMembershipApplicationID)
+	if err != nil || !assigned {
+		return Team{}, err
+	}
+
+	team := teamsFromGroups([]Group{*group})[strings.ToLower(group.MailNickname)]
+	if p.options.TransitiveMembers && team.Valid() {
+		team.Groups, team.Members, err = graphAPI.TransitiveMembers(team.AzureUUID)
+		if err != nil {
+			return Team{}, fmt.Errorf("transitive members of '%s': %s", team.ID, err)
+		}
+	}
+	return team, nil
+}
+
+// Refuse a group list that is much smaller than the previous one, unless it is consistently returned.
+func (p *Provider) checkTruncation(assigned int) error {
+	if float64(assigned) >= float64(p.assigned)*truncationRatio {
+		p.assigned = assigned
+		p.truncated = 0
+		return nil
+	}
+
+	p.truncated++
+	if p.truncated >= truncationAttempts {
+		log.Warnf("azure: accepting reduction from %d to %d assigned groups after %d consecutive syncs", p.assigned, assigned, p.truncated)
+		p.assigned = assigned
+		p.truncated = 0
+		return nil
+	}
+
+	return fmt.Errorf("refusing to replace %d assigned groups with %d; the group list appears truncated", p.assigned, assigned)
+}
+
 // DefaultContext returns a context that will time out.
 // Remember to call CancelFunc when you are done.
 func DefaultContext(timeout time.Duration) (context.Context, context.CancelFunc) {
@@ -0,0 +1,120 @@
+package azure
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// How long a signed client assertion is valid.
+const assertionLifetime = 10 * time.Minute
+
+// Read the private key from a PEM file. If no thumbprint is given, it is calculated from the certificate in the same file.
+func loadCertificate(path, thumbprint string) (*rsa.PrivateKey, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while reading certificate file: %s", err)
+	}
+
+	var key *rsa.PrivateKey
+	var certificate *x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var parsed interface{}
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err == nil {
+				var ok bool
+				key, ok = parsed.(*rsa.PrivateKey)
+				if !ok {
+					err = fmt.Errorf("only RSA private keys are supported")
+				}
+			}
+		case "CERTIFICATE":
+			certificate, err = x509.ParseCertificate(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("while parsing %s: %s", strings.ToLower(block.Type), err)
+		}
+	}
+
+	if key == nil {
+		return nil, nil, fmt.Errorf("no private key found in certificate file")
+	}
+
+	if len(thumbprint) > 0 {
+		digest, err := hex.DecodeString(strings.Replace(thumbprint, ":", "", -1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("while decoding certificate thumbprint: %s", err)
+		}
+		return key, digest, nil
+	}
+
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("certificate thumbprint must be specified when certificate file contains no certificate")
+	}
+
+	digest := sha1.Sum(certificate.Raw)
+	return key, digest[:], nil
+}
+
+// Create a client assertion JWT signed with the certificate's private key.
+// https://docs.microsoft.com/en-us/azure/active-directory/develop/active-directory-certificate-credentials
+func signedAssertion(key *rsa.PrivateKey, thumbprint []byte, clientID, audience string, now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint),
+	}
+	claims := map[string]interface{}{
+		"aud": audience,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	}
+
+	segments := make([]string, 0, 3)
+	for _, part := range []interface{}{header, claims} {
+		data, err := json.Marshal(part)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, base64.RawURLEncoding.EncodeToString(data))
+	}
+
+	signingInput := strings.Join(segments, ".")
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,71 @@
+package azure
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	now := time.Unix(1500000000, 0)
+	assertion, err := signedAssertion(key, []byte{0xde, 0xad, 0xbe, 0xef}, "client", "https://login.example.com/token", now)
+	assert.NoError(t, err)
+
+	parts := strings.Split(assertion, ".")
+	assert.Len(t, parts, 3)
+
+	header := make(map[string]string)
+	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	assert.NoError(t, json.Unmarshal(data, &header))
+	assert.Equal(t, "RS256", header["alg"])
+	assert.Equal(t, "3q2-7w", header["x5t"])
+
+	claims := make(map[string]interface{})
+	data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, json.Unmarshal(data, &claims))
+	assert.Equal(t, "client", claims["iss"])
+	assert.Equal(t, "client", claims["sub"])
+	assert.Equal(t, "https://login.example.com/token", claims["aud"])
+	assert.Equal(t, float64(now.Add(assertionLifetime).Unix()), claims["exp"])
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+}
+
+func TestLoadCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "tobac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	_, _, err = loadCertificate(path, "")
+	assert.Error(t, err, "thumbprint is required without certificate")
+
+	loaded, thumbprint, err := loadCertificate(path, "DE:AD:BE:EF")
+	assert.NoError(t, err)
+	assert.Equal(t, key.D, loaded.D)
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, thumbprint)
+}
@@ -0,0 +1,312 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/tobac/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/microsoft"
+)
+
+const (
+	// AuthClientSecret authenticates with the application's client ID and secret.
+	AuthClientSecret = "client-secret"
+	// AuthWorkloadIdentity exchanges a Kubernetes service account token for an access token, using workload identity federation.
+	AuthWorkloadIdentity = "workload-identity"
+	// AuthManagedIdentity retrieves an access token from the Azure instance metadata service.
+	AuthManagedIdentity = "managed-identity"
+	// AuthCertificate authenticates with a client assertion signed by the application's certificate.
+	AuthCertificate = "certificate"
+)
+
+const (
+	graphScope             = "https://graph.microsoft.com/.default"
+	graphResource          = "https://graph.microsoft.com/"
+	clientAssertionType    = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	managedIdentityAddress = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// Authentication configures how to authenticate against Microsoft Graph.
+type Authentication struct {
+	Method   string
+	ClientID string
+	TenantID string
+	// Client secret of the application. Ignored if a client secret file is specified.
+	ClientSecret string
+	// File containing the client secret, read on every token refresh so that the secret can be rotated.
+	ClientSecretFile string
+	// Path to the projected service account token used for workload identity.
+	FederatedTokenFile string
+	// Client ID of a user-assigned managed identity. Leave empty to use the system-assigned identity.
+	ManagedIdentityClientID string
+	// PEM file with the private key of the application certificate, and optionally the certificate itself.
+	CertificateFile string
+	// Hex encoded SHA-1 thumbprint of the application certificate. Derived from the certificate file if empty.
+	CertificateThumbprint string
+}
+
+var authentication = Authentication{
+	Method:             AuthClientSecret,
+	FederatedTokenFile: os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+}
+
+// SetAuthentication configures the authentication method used for all subsequent Microsoft Graph requests.
+func SetAuthentication(a Authentication) error {
+	switch a.Method {
+	case AuthManagedIdentity:
+	case AuthClientSecret:
+		_, err := a.secret()
+		if err != nil {
+			return err
+		}
+	case AuthWorkloadIdentity:
+		if len(a.FederatedTokenFile) == 0 {
+			return fmt.Errorf("workload identity requires a federated token file")
+		}
+	case AuthCertificate:
+		_, _, err := loadCertificate(a.CertificateFile, a.CertificateThumbprint)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("authentication method '%s' is not recognized", a.Method)
+	}
+	authentication = a
+	resetTokenCache()
+	return nil
+}
+
+// Returns the client secret, reading it from the client secret file if specified.
+func (a Authentication) secret() (string, error) {
+	if len(a.ClientSecretFile) == 0 {
+		return a.ClientSecret, nil
+	}
+	data, err := ioutil.ReadFile(a.ClientSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("while reading client secret: %s", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Workload identity webhooks inject the standard Azure SDK environment variables.
+func envOrDefault(value, key string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// Tokens are refreshed when they are this close to expiry, so that requests never race an expiring token.
+const tokenRefreshMargin = 5 * time.Minute
+
+var tokenCache struct {
+	mutex  sync.Mutex
+	source *cachingTokenSource
+}
+
+// Token source shared by all Microsoft Graph clients, so that tokens are reused across synchronizations.
+func tokens() oauth2.TokenSource {
+	tokenCache.mutex.Lock()
+	defer tokenCache.mutex.Unlock()
+	if tokenCache.source == nil {
+		// Token requests outlive the context of any single query.
+		ctx := context.Background()
+		if httpClient != nil {
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		}
+		tokenCache.source = &cachingTokenSource{source: tokenSource(ctx)}
+	}
+	return tokenCache.source
+}
+
+// Discard the cached token, e.g. when authentication settings change.
+func resetTokenCache() {
+	tokenCache.mutex.Lock()
+	tokenCache.source = nil
+	tokenCache.mutex.Unlock()
+}
+
+// cachingTokenSource reuses a token until it is about to expire, and exposes its expiry as a metric.
+type cachingTokenSource struct {
+	mutex  sync.Mutex
+	source oauth2.TokenSource
+	token  *oauth2.Token
+}
+
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != nil && time.Until(s.token.Expiry) > tokenRefreshMargin {
+		return s.token, nil
+	}
+
+	token, err := s.source.Token()
+	if err != nil {
+		// Keep using the current token until it actually expires.
+		if s.token.Valid() {
+			log.Warnf("azure: while refreshing access token, continuing with current token: %s", err)
+			return s.token, nil
+		}
+		return nil, err
+	}
+
+	log.Debugf("azure: refreshed access token, expires at %s", token.Expiry)
+	metrics.AzureTokenExpiry.Set(float64(token.Expiry.Unix()))
+	s.token = token
+	return token, nil
+}
+
+func tokenSource(ctx context.Context) oauth2.TokenSource {
+	switch authentication.Method {
+	case AuthWorkloadIdentity:
+		return &federatedTokenSource{ctx: ctx}
+	case AuthManagedIdentity:
+		return &managedIdentityTokenSource{ctx: ctx}
+	case AuthCertificate:
+		return &certificateTokenSource{ctx: ctx}
+	default:
+		return &secretTokenSource{ctx: ctx}
+	}
+}
+
+// secretTokenSource reads the client secret on every refresh, so that a rotated secret is picked up.
+type secretTokenSource struct {
+	ctx context.Context
+}
+
+func (s *secretTokenSource) Token() (*oauth2.Token, error) {
+	secret, err := authentication.secret()
+	if err != nil {
+		return nil, err
+	}
+
+	config := clientcredentials.Config{
+		ClientID:     authentication.ClientID,
+		ClientSecret: secret,
+		Scopes:       []string{graphScope},
+		TokenURL:     microsoft.AzureADEndpoint(authentication.TenantID).TokenURL,
+	}
+
+	return config.Token(s.ctx)
+}
+
+// federatedTokenSource reads the service account token on every refresh, since it is rotated by the kubelet.
+type federatedTokenSource struct {
+	ctx context.Context
+}
+
+func (s *federatedTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := ioutil.ReadFile(authentication.FederatedTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("while reading federated token: %s", err)
+	}
+
+	config := assertionConfig(envOrDefault(authentication.ClientID, "AZURE_CLIENT_ID"), envOrDefault(authentication.TenantID, "AZURE_TENANT_ID"), strings.TrimSpace(string(assertion)))
+
+	return config.Token(s.ctx)
+}
+
+// certificateTokenSource signs a new client assertion on every refresh, so that a renewed certificate is picked up.
+type certificateTokenSource struct {
+	ctx context.Context
+}
+
+func (s *certificateTokenSource) Token() (*oauth2.Token, error) {
+	key, thumbprint, err := loadCertificate(authentication.CertificateFile, authentication.CertificateThumbprint)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenURL := microsoft.AzureADEndpoint(authentication.TenantID).TokenURL
+	assertion, err := signedAssertion(key, thumbprint, authentication.ClientID, tokenURL, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("while signing client assertion: %s", err)
+	}
+
+	config := assertionConfig(authentication.ClientID, authentication.TenantID, assertion)
+
+	return config.Token(s.ctx)
+}
+
+func assertionConfig(clientID, tenantID, assertion string) clientcredentials.Config {
+	return clientcredentials.Config{
+		ClientID: clientID,
+		Scopes:   []string{graphScope},
+		TokenURL: microsoft.AzureADEndpoint(tenantID).TokenURL,
+		EndpointParams: url.Values{
+			"client_assertion_type": []string{clientAssertionType},
+			"client_assertion":      []string{assertion},
+		},
+	}
+}
+
+// managedIdentityTokenSource retrieves tokens from the Azure instance metadata service.
+// https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
+type managedIdentityTokenSource struct {
+	ctx context.Context
+}
+
+func (s *managedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	queryParams := url.Values{}
+	queryParams.Set("api-version", "2018-02-01")
+	queryParams.Set("resource", graphResource)
+	if len(authentication.ManagedIdentityClientID) > 0 {
+		queryParams.Set("client_id", authentication.ManagedIdentityClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, managedIdentityAddress+"?"+queryParams.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(s.ctx)
+	req.Header.Set("Metadata", "true")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while requesting managed identity token: %s", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode > 299 {
+		return nil, fmt.Errorf("managed identity token: %s: %s", response.Status, string(body))
+	}
+
+	result := &struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresOn, err := strconv.ParseInt(result.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing managed identity token expiry: %s", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: result.AccessToken,
+		TokenType:   result.TokenType,
+		Expiry:      time.Unix(expiresOn, 0),
+	}, nil
+}
@@ -0,0 +1,73 @@
+package azure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+type countingTokenSource struct {
+	calls  int
+	expiry time.Duration
+	fail   bool
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.calls++
+	if s.fail {
+		return nil, fmt.Errorf("unavailable")
+	}
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", s.calls), Expiry: time.Now().Add(s.expiry)}, nil
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	source := &countingTokenSource{expiry: time.Hour}
+	cache := &cachingTokenSource{source: source}
+
+	for i := 0; i < 3; i++ {
+		token, err := cache.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, "token-1", token.AccessToken)
+	}
+	assert.Equal(t, 1, source.calls)
+
+	// Tokens close to expiry are refreshed, but kept if the refresh fails.
+	source.expiry = time.Minute
+	cache.token = nil
+	token, _ := cache.Token()
+	assert.Equal(t, "token-2", token.AccessToken)
+
+	source.fail = true
+	token, err := cache.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token.AccessToken)
+	assert.Equal(t, 3, source.calls)
+}
+
+func TestClientSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	auth := Authentication{ClientSecret: "from-environment", ClientSecretFile: path}
+
+	_, err := auth.secret()
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+	secret, err := auth.secret()
+	assert.NoError(t, err)
+	assert.Equal(t, "first", secret)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("rotated"), 0600))
+	secret, err = auth.secret()
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated", secret)
+
+	auth.ClientSecretFile = ""
+	secret, err = auth.secret()
+	assert.NoError(t, err)
+	assert.Equal(t, "from-environment", secret)
+}
@@ -1,14 +1,26 @@
 package azure
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// DefaultConcurrency is the number of concurrent queries used when retrieving many groups.
+const DefaultConcurrency = 8
+
 type GraphAPI struct {
+	ctx    context.Context
 	client *http.Client
 }
 
@@ -25,60 +37,261 @@ type ServicePrincipalList struct {
 type Group struct {
 	ID           string `json:"id"`
 	DisplayName  string `json:"displayName"`
+	Description  string `json:"description"`
+	Mail         string `json:"mail"`
 	MailNickname string `json:"mailNickname"`
 }
 
+// DirectoryObject is a member of a group, either a user or another group.
+type DirectoryObject struct {
+	Type              string `json:"@odata.type"`
+	ID                string `json:"id"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+type DirectoryObjectList struct {
+	NextLink string            `json:"@odata.nextLink"`
+	Value    []DirectoryObject `json:"value"`
+}
+
 type GroupList struct {
 	Value []Group
 }
 
-func NewGraphAPI(client *http.Client) *GraphAPI {
+// GroupChange is a group returned from a delta query. Removed is set if the group was deleted.
+// Delta queries only return the properties that changed, which are listed in Properties.
+type GroupChange struct {
+	Group
+	Removed *struct {
+		Reason string `json:"reason"`
+	} `json:"@removed"`
+	Properties map[string]bool `json:"-"`
+}
+
+func (c *GroupChange) UnmarshalJSON(data []byte) error {
+	type groupChange GroupChange
+	change := groupChange{}
+	err := json.Unmarshal(data, &change)
+	if err != nil {
+		return err
+	}
+	properties := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &properties)
+	if err != nil {
+		return err
+	}
+	change.Properties = make(map[string]bool, len(properties))
+	for property := range properties {
+		change.Properties[property] = true
+	}
+	*c = GroupChange(change)
+	return nil
+}
+
+// Merge returns the group with the properties present in the change applied.
+func (c GroupChange) Merge(group Group) Group {
+	if c.Properties["displayName"] {
+		group.DisplayName = c.DisplayName
+	}
+	if c.Properties["description"] {
+		group.Description = c.Description
+	}
+	if c.Properties["mail"] {
+		group.Mail = c.Mail
+	}
+	if c.Properties["mailNickname"] {
+		group.MailNickname = c.MailNickname
+	}
+	return group
+}
+
+type GroupDeltaList struct {
+	NextLink  string        `json:"@odata.nextLink"`
+	DeltaLink string        `json:"@odata.deltaLink"`
+	Value     []GroupChange `json:"value"`
+}
+
+// NewGraphAPI returns a Graph API client. Queries are cancelled when the context is done.
+func NewGraphAPI(ctx context.Context, client *http.Client) *GraphAPI {
 	return &GraphAPI{
+		ctx:    ctx,
 		client: client,
 	}
 }
 
 // Retrieve a list of Azure Groups that are given access to a specific Azure Application.
 func (g *GraphAPI) GroupsFromApplication(appID string) ([]Group, error) {
+	groupIDs, err := g.GroupIDsFromApplication(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := g.Groups(groupIDs, DefaultConcurrency)
+	if err != nil {
+		return nil, fmt.Errorf("recurse into groups: %s", err)
+	}
+
+	return groups, nil
+}
+
+// Retrieve several groups, with at most `concurrency` queries in flight.
+// Groups are returned in the same order as the IDs.
+func (g *GraphAPI) Groups(groupIDs []string, concurrency int) ([]Group, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	groups := make([]Group, len(groupIDs))
+	errs := make([]error, len(groupIDs))
+	indices := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency && i < len(groupIDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				// Drain the remaining work without querying once any query has failed.
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				group, err := g.Group(groupIDs[index])
+				if err != nil {
+					errs[index] = err
+					atomic.StoreInt32(&failed, 1)
+					continue
+				}
+				groups[index] = *group
+			}
+		}()
+	}
+
+	for index := range groupIDs {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("group '%s': %s", groupIDs[index], err)
+		}
+	}
+
+	return groups, nil
+}
+
+// Retrieve the IDs of Azure Groups that are given access to a specific Azure Application.
+func (g *GraphAPI) GroupIDsFromApplication(appID string) ([]string, error) {
 	servicePrincipals, err := g.servicePrincipalsInApplication(appID)
 	if err != nil {
 		return nil, fmt.Errorf("get parent group: %s", err)
 	}
 
-	groups := make([]Group, 0)
+	groupIDs := make([]string, 0)
 	for _, servicePrincipal := range servicePrincipals {
 		if servicePrincipal.PrincipalType != "Group" {
 			continue
 		}
-		group, err := g.group(servicePrincipal.PrincipalID)
+		groupIDs = append(groupIDs, servicePrincipal.PrincipalID)
+	}
+
+	return groupIDs, nil
+}
+
+// Retrieve a delta link that can be used to query group changes from this point in time.
+// https://docs.microsoft.com/en-us/graph/delta-query-overview#use-delta-query-to-track-changes-in-a-resource-collection
+func (g *GraphAPI) LatestGroupDeltaLink() (string, error) {
+	queryParams := url.Values{}
+	queryParams.Set("$select", "id,displayName,description,mail,mailNickname")
+	queryParams.Set("$deltaToken", "latest")
+
+	_, body, err := g.query("https://graph.microsoft.com/v1.0/groups/delta?" + queryParams.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	deltaList := &GroupDeltaList{}
+	err = json.Unmarshal(body, deltaList)
+	if err != nil {
+		return "", err
+	}
+	if len(deltaList.DeltaLink) == 0 {
+		return "", fmt.Errorf("delta query did not return a delta link")
+	}
+
+	return deltaList.DeltaLink, nil
+}
+
+// Retrieve all group changes since the delta link was issued, and a new delta link for the next query.
+func (g *GraphAPI) GroupDelta(deltaLink string) ([]GroupChange, string, error) {
+	changes := make([]GroupChange, 0)
+	nextURL := deltaLink
+
+	for {
+		_, body, err := g.query(nextURL)
 		if err != nil {
-			return nil, fmt.Errorf("recurse into groups: %s", err)
+			return nil, "", err
 		}
-		groups = append(groups, *group)
-	}
 
-	return groups, nil
+		deltaList := &GroupDeltaList{}
+		err = json.Unmarshal(body, deltaList)
+		if err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, deltaList.Value...)
+
+		if len(deltaList.DeltaLink) > 0 {
+			return changes, deltaList.DeltaLink, nil
+		}
+		if len(deltaList.NextLink) == 0 {
+			return nil, "", fmt.Errorf("delta query returned neither next link nor delta link")
+		}
+		nextURL = deltaList.NextLink
+	}
 }
 
-// https://docs.microsoft.com/en-us/graph/api/approleassignment-get?view=graph-rest-beta&tabs=http
+// Upper bound on the number of pages retrieved, to avoid looping forever on a misbehaving API.
+const maxPages = 1000
+
+// https://docs.microsoft.com/en-us/graph/api/serviceprincipal-list-approleassignedto?view=graph-rest-1.0
 func (g *GraphAPI) servicePrincipalsInApplication(appID string) ([]ServicePrincipal, error) {
 	servicePrincipals := make([]ServicePrincipal, 0)
 
 	queryParams := url.Values{}
 	queryParams.Set("$top", "999")
 	queryParams.Set("$select", "principalId,principalType")
-	nextURL := fmt.Sprintf("https://graph.microsoft.com/beta/servicePrincipals/%s/appRoleAssignedTo?%s", appID, queryParams.Encode())
+	nextURL := fmt.Sprintf("https://graph.microsoft.com/v1.0/servicePrincipals/%s/appRoleAssignedTo?%s", url.PathEscape(appID), queryParams.Encode())
+	seen := make(map[string]bool)
+
+	for page := 1; len(nextURL) != 0; page++ {
+		if page > maxPages {
+			return nil, fmt.Errorf("app role assignments exceed %d pages", maxPages)
+		}
+		if seen[nextURL] {
+			return nil, fmt.Errorf("app role assignments page %d links back to a previous page", page)
+		}
+		seen[nextURL] = true
 
-	for len(nextURL) != 0 {
 		_, body, err := g.query(nextURL)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("app role assignments page %d: %s", page, err)
 		}
 
 		servicePrincipalList := &ServicePrincipalList{}
 		err = json.Unmarshal(body, servicePrincipalList)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("app role assignments page %d: %s", page, err)
+		}
+		// An empty list is decoded as a non-nil slice, so nil means the value was missing from the response.
+		if servicePrincipalList.Value == nil {
+			return nil, fmt.Errorf("app role assignments page %d has no value", page)
+		}
+		for _, servicePrincipal := range servicePrincipalList.Value {
+			if len(servicePrincipal.PrincipalID) == 0 {
+				return nil, fmt.Errorf("app role assignments page %d contains assignment without principal", page)
+			}
 		}
 		servicePrincipals = append(servicePrincipals, servicePrincipalList.Value...)
 		nextURL = servicePrincipalList.NextLink
@@ -87,11 +300,80 @@ func (g *GraphAPI) servicePrincipalsInApplication(appID string) ([]ServicePrinci
 	return servicePrincipals, nil
 }
 
-func (g *GraphAPI) group(groupID string) (*Group, error) {
+// Retrieve the IDs of all nested groups and the user principal names of all users that are members
+// of a group, either directly or through nested groups.
+// https://docs.microsoft.com/en-us/graph/api/group-list-transitivemembers?view=graph-rest-1.0
+func (g *GraphAPI) TransitiveMembers(groupID string) (groupIDs []string, usernames []string, err error) {
+	queryParams := url.Values{}
+	queryParams.Set("$top", "999")
+	queryParams.Set("$select", "id,userPrincipalName")
+	nextURL := fmt.Sprintf("https://graph.microsoft.com/v1.0/groups/%s/transitiveMembers?%s", groupID, queryParams.Encode())
+
+	for len(nextURL) != 0 {
+		_, body, err := g.query(nextURL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		memberList := &DirectoryObjectList{}
+		err = json.Unmarshal(body, memberList)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, member := range memberList.Value {
+			switch member.Type {
+			case "#microsoft.graph.group":
+				groupIDs = append(groupIDs, member.ID)
+			case "#microsoft.graph.user":
+				usernames = append(usernames, member.UserPrincipalName)
+			}
+		}
+		nextURL = memberList.NextLink
+	}
+
+	return groupIDs, usernames, nil
+}
+
+// Return the subset of the specified groups that the user is a member of, either directly or transitively.
+// https://docs.microsoft.com/en-us/graph/api/directoryobject-checkmembergroups?view=graph-rest-1.0
+func (g *GraphAPI) CheckMemberGroups(ctx context.Context, user string, groupIDs []string) ([]string, error) {
+	payload, err := json.Marshal(map[string][]string{
+		"groupIds": groupIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u := fmt.Sprintf("https://graph.microsoft.com/v1.0/users/%s/checkMemberGroups", url.PathEscape(user))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, body, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &struct {
+		Value []string `json:"value"`
+	}{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Value, nil
+}
+
+// Retrieve a single group.
+func (g *GraphAPI) Group(groupID string) (*Group, error) {
 	u := fmt.Sprintf("https://graph.microsoft.com/v1.0/groups/%s", groupID)
 
 	queryParams := url.Values{}
-	queryParams.Set("$select", "id,displayName,mailNickname")
+	queryParams.Set("$select", "id,displayName,description,mail,mailNickname")
 
 	group := &Group{}
 	_, body, err := g.query(u + "?" + queryParams.Encode())
@@ -107,11 +389,122 @@ func (g *GraphAPI) group(groupID string) (*Group, error) {
 	return group, nil
 }
 
+// Retrieve the group with the specified mail nickname, or nil if there is no such group.
+func (g *GraphAPI) GroupByMailNickname(nickname string) (*Group, error) {
+	queryParams := url.Values{}
+	queryParams.Set("$filter", fmt.Sprintf("mailNickname eq '%s'", strings.Replace(nickname, "'", "''", -1)))
+	queryParams.Set("$select", "id,displayName,description,mail,mailNickname")
+
+	_, body, err := g.query("https://graph.microsoft.com/v1.0/groups?" + queryParams.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	groups := &GroupList{}
+	err = json.Unmarshal(body, groups)
+	if err != nil {
+		return nil, err
+	}
+	switch len(groups.Value) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &groups.Value[0], nil
+	default:
+		return nil, fmt.Errorf("%d groups have the mail nickname '%s'", len(groups.Value), nickname)
+	}
+}
+
+// Check whether a group is given access to a specific Azure Application.
+// https://docs.microsoft.com/en-us/graph/api/group-list-approleassignments?view=graph-rest-1.0
+func (g *GraphAPI) AssignedToApplication(groupID, appID string) (bool, error) {
+	queryParams := url.Values{}
+	queryParams.Set("$select", "principalId,principalType,resourceId")
+	u := fmt.Sprintf("https://graph.microsoft.com/v1.0/groups/%s/appRoleAssignments?%s", url.PathEscape(groupID), queryParams.Encode())
+
+	for page := 1; len(u) != 0; page++ {
+		if page > maxPages {
+			return false, fmt.Errorf("app role assignments exceed %d pages", maxPages)
+		}
+		_, body, err := g.query(u)
+		if err != nil {
+			return false, err
+		}
+		assignments := &struct {
+			NextLink string `json:"@odata.nextLink"`
+			Value    []struct {
+				ResourceID string `json:"resourceId"`
+			} `json:"value"`
+		}{}
+		err = json.Unmarshal(body, assignments)
+		if err != nil {
+			return false, err
+		}
+		for _, assignment := range assignments.Value {
+			if assignment.ResourceID == appID {
+				return true, nil
+			}
+		}
+		u = assignments.NextLink
+	}
+
+	return false, nil
+}
+
+// Perform a GET request, retrying transient failures according to the retry policy.
+// Throttled requests are retried after the delay requested by the server, until the context is done.
 func (g *GraphAPI) query(url string) (response *http.Response, body []byte, err error) {
-	response, err = g.client.Get(url)
+	attempt := 0
+	for {
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(g.ctx)
+
+		response, body, err = g.do(req)
+		if err == nil || g.ctx.Err() != nil {
+			return
+		}
+
+		if response != nil && response.StatusCode == http.StatusTooManyRequests {
+			delay, ok := retryAfter(response)
+			if !ok {
+				delay = retryPolicy.backoff(attempt)
+			}
+			log.Warnf("azure: throttled by Microsoft Graph, pausing queries for %s", delay)
+			throttle.delay(delay)
+			continue
+		}
+
+		if attempt >= retryPolicy.MaxRetries || !transient(response) {
+			return
+		}
+
+		delay := retryPolicy.backoff(attempt)
+		attempt++
+		log.Debugf("azure: retrying query in %s after error: %s", delay, err)
+
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (g *GraphAPI) do(req *http.Request) (response *http.Response, body []byte, err error) {
+	err = throttle.wait(req.Context())
+	if err != nil {
+		return
+	}
+
+	response, err = g.client.Do(req)
 	if err != nil {
 		return
 	}
+	defer response.Body.Close()
 
 	body, err = ioutil.ReadAll(response.Body)
 	if err != nil {
@@ -0,0 +1,293 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRetriesTransientErrors(t *testing.T) {
+	SetRetryPolicy(RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/flaky":
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	graphAPI := NewGraphAPI(context.Background(), server.Client())
+
+	_, body, err := graphAPI.query(server.URL + "/flaky")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, 3, requests)
+
+	requests = 0
+	_, _, err = graphAPI.query(server.URL + "/missing")
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests, "client errors are not retried")
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay := policy.backoff(attempt)
+		assert.True(t, delay >= max/2 && delay <= max, "attempt %d: %s not within [%s, %s]", attempt, delay, max/2, max)
+	}
+}
+
+func TestQueryHonorsRetryAfter(t *testing.T) {
+	SetRetryPolicy(RetryPolicy{MaxRetries: 0, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	graphAPI := NewGraphAPI(context.Background(), server.Client())
+
+	_, body, err := graphAPI.query(server.URL)
+	assert.NoError(t, err, "throttled requests are retried regardless of retry policy")
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, 3, requests)
+}
+
+func TestRetryAfter(t *testing.T) {
+	response := &http.Response{Header: http.Header{}}
+
+	_, ok := retryAfter(response)
+	assert.False(t, ok)
+
+	response.Header.Set("Retry-After", "120")
+	delay, ok := retryAfter(response)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	response.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	delay, ok = retryAfter(response)
+	assert.True(t, ok)
+	assert.True(t, delay > 59*time.Minute && delay <= time.Hour)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGroupsConcurrency(t *testing.T) {
+	var inflight, peak int32
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		current := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if current <= p || atomic.CompareAndSwapInt32(&peak, p, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		id := path.Base(r.URL.Path)
+		body := fmt.Sprintf(`{"id":"%s","mailNickname":"team-%s"}`, id, id)
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	ids := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	groups, err := NewGraphAPI(context.Background(), client).Groups(ids, 3)
+	assert.NoError(t, err)
+	assert.Len(t, groups, len(ids))
+	for i, group := range groups {
+		assert.Equal(t, ids[i], group.ID)
+		assert.Equal(t, "team-"+ids[i], group.MailNickname)
+	}
+	assert.True(t, peak <= 3, "peak concurrency %d exceeds limit", peak)
+}
+
+func TestServicePrincipalPagination(t *testing.T) {
+	pages := map[string]string{
+		"/first":     `{"value":[{"principalId":"1","principalType":"Group"}],"@odata.nextLink":"https://graph/second"}`,
+		"/second":    `{"value":[{"principalId":"2","principalType":"User"}]}`,
+		"/loop":      `{"value":[],"@odata.nextLink":"https://graph/loop"}`,
+		"/malformed": `{"error":"something"}`,
+	}
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		page := pages[r.URL.Path]
+		if strings.HasSuffix(r.URL.Path, "/appRoleAssignedTo") {
+			page = pages["/"]
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(page))}, nil
+	})}
+	graphAPI := NewGraphAPI(context.Background(), client)
+
+	pages["/"] = pages["/first"]
+	principals, err := graphAPI.servicePrincipalsInApplication("app")
+	assert.NoError(t, err)
+	assert.Len(t, principals, 2)
+
+	pages["/"] = pages["/loop"]
+	_, err = graphAPI.servicePrincipalsInApplication("app")
+	assert.Error(t, err)
+
+	pages["/"] = pages["/malformed"]
+	_, err = graphAPI.servicePrincipalsInApplication("app")
+	assert.Error(t, err)
+}
+
+func TestGroupDelta(t *testing.T) {
+	pages := map[string]string{
+		"/first":  `{"value":[{"id":"1","displayName":"Renamed"},{"id":"2","@removed":{"reason":"changed"}}],"@odata.nextLink":"https://graph/second"}`,
+		"/second": `{"value":[{"id":"3","members@delta":[]}],"@odata.deltaLink":"https://graph/next"}`,
+		"/broken": `{"value":[]}`,
+	}
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(pages[r.URL.Path]))}, nil
+	})}
+	graphAPI := NewGraphAPI(context.Background(), client)
+
+	changes, deltaLink, err := graphAPI.GroupDelta("https://graph/first")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://graph/next", deltaLink)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, map[string]bool{"id": true, "displayName": true}, changes[0].Properties)
+	assert.Nil(t, changes[0].Removed)
+	assert.NotNil(t, changes[1].Removed)
+
+	_, _, err = graphAPI.GroupDelta("https://graph/broken")
+	assert.Error(t, err, "a page without next link or delta link is an error")
+}
+
+func TestGroupChangeMerge(t *testing.T) {
+	cached := Group{ID: "1", DisplayName: "Team", Description: "A team", Mail: "team@example.com", MailNickname: "team"}
+
+	change := GroupChange{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"1","displayName":"Renamed","description":""}`), &change))
+	merged := change.Merge(cached)
+	assert.Equal(t, Group{ID: "1", DisplayName: "Renamed", Description: "", Mail: "team@example.com", MailNickname: "team"}, merged)
+
+	change = GroupChange{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"1","members@delta":[{"id":"user"}]}`), &change))
+	assert.Equal(t, cached, change.Merge(cached), "changes without group properties keep the cached group")
+}
+
+func TestApplyDelta(t *testing.T) {
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"value":[{"id":"1","mailNickname":"renamed"},{"id":"2","@removed":{"reason":"deleted"}},{"id":"3","displayName":"Unknown"}],"@odata.deltaLink":"https://graph/next"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}
+	p := NewProvider(Options{Incremental: true})
+	p.deltaLink = "https://graph/delta"
+	p.groups["1"] = Group{ID: "1", DisplayName: "One", MailNickname: "one"}
+	p.groups["2"] = Group{ID: "2", MailNickname: "two"}
+
+	assert.NoError(t, p.applyDelta(NewGraphAPI(context.Background(), client)))
+	assert.Equal(t, "https://graph/next", p.deltaLink)
+	assert.Equal(t, Group{ID: "1", DisplayName: "One", MailNickname: "renamed"}, p.groups["1"])
+	assert.NotContains(t, p.groups, "2")
+	assert.NotContains(t, p.groups, "3", "groups that are not cached are retrieved in full when assigned")
+}
+
+func TestCheckTruncation(t *testing.T) {
+	p := NewProvider(Options{})
+	assert.NoError(t, p.checkTruncation(100))
+	assert.NoError(t, p.checkTruncation(60))
+	assert.Error(t, p.checkTruncation(10))
+	assert.Error(t, p.checkTruncation(10))
+	assert.NoError(t, p.checkTruncation(10), "consistent results are eventually accepted")
+	assert.NoError(t, p.checkTruncation(10))
+}
+
+func TestSharePointTeams(t *testing.T) {
+	var requested string
+	httpClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "graph.microsoft.com" {
+			requested = r.URL.String()
+			body := `{"value":[
+				{"fields":{"Slug":"Team-A","Title":"Team A","GroupID":"uuid-a","Slack":"#team-a"}},
+				{"fields":{"Slug":"team-b","Members":"alice@example.com, bob@example.com"}},
+				{"fields":{"Title":"No slug"}}
+			]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		}
+		body := `{"access_token":"token","token_type":"Bearer","expires_in":3600}`
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"application/json"}}, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}
+	resetTokenCache()
+	defer func() {
+		httpClient = nil
+		resetTokenCache()
+	}()
+
+	provider := NewSharePointProvider(SharePointConfig{
+		Site: "example.sharepoint.com:/sites/platform",
+		List: "Teams",
+		Fields: SharePointFields{
+			ID:      "Slug",
+			Title:   "Title",
+			Contact: "Slack",
+			Group:   "GroupID",
+			Members: "Members",
+		},
+	})
+
+	teams, err := provider.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, requested, "/sites/example.sharepoint.com:/sites/platform:/lists/Teams/items")
+	assert.Len(t, teams, 2)
+	assert.Equal(t, Team{ID: "team-a", Title: "Team A", Contact: "#team-a", AzureUUID: "uuid-a"}, teams["team-a"])
+	assert.Equal(t, []string{"alice@example.com", "bob@example.com"}, teams["team-b"].Members)
+}
+
+func TestNotifications(t *testing.T) {
+	notifications := NewNotifications("https://tobac.example.com/azure/notifications", "secret")
+	notifications.Watch([]string{"team-group"})
+
+	recorder := httptest.NewRecorder()
+	notifications.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/azure/notifications?validationToken=Validation%3A+token", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "Validation: token", recorder.Body.String())
+
+	notify := func(clientState, groupID string) bool {
+		body := fmt.Sprintf(`{"value":[{"subscriptionId":"s","clientState":"%s","changeType":"updated","resourceData":{"id":"%s"}}]}`, clientState, groupID)
+		recorder := httptest.NewRecorder()
+		notifications.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/azure/notifications", strings.NewReader(body)))
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		select {
+		case <-notifications.Changes():
+			return true
+		default:
+			return false
+		}
+	}
+
+	assert.True(t, notify("secret", "team-group"))
+	assert.False(t, notify("secret", "other-group"))
+	assert.False(t, notify("forged", "team-group"))
+}
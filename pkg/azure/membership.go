@@ -0,0 +1,91 @@
+package azure
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type membershipEntry struct {
+	member  bool
+	expires time.Time
+}
+
+// MembershipLookup checks team membership directly against Microsoft Graph. This covers users whose tokens
+// do not include group claims, e.g. because they are members of more groups than the token can hold.
+// Results are cached for a while, so that repeated requests from the same user do not hit the API.
+type MembershipLookup struct {
+	timeout time.Duration
+	ttl     time.Duration
+	mutex   sync.Mutex
+	cache   map[string]membershipEntry
+	check   func(ctx context.Context, username string, groupIDs []string) ([]string, error)
+}
+
+func NewMembershipLookup(timeout, ttl time.Duration) *MembershipLookup {
+	return &MembershipLookup{
+		timeout: timeout,
+		ttl:     ttl,
+		cache:   make(map[string]membershipEntry),
+		check: func(ctx context.Context, username string, groupIDs []string) ([]string, error) {
+			return NewGraphAPI(ctx, client(ctx)).CheckMemberGroups(ctx, username, groupIDs)
+		},
+	}
+}
+
+// IsMember returns true if the user is a member of any of the team's groups. The lookup is abandoned
+// when the request context is done or the lookup timeout has passed, whichever comes first.
+// Lookup failures are logged and treated as non-membership.
+func (m *MembershipLookup) IsMember(ctx context.Context, username string, team Team) bool {
+	// Kubernetes service accounts and other system users do not exist in Azure AD.
+	if strings.HasPrefix(username, "system:") {
+		return false
+	}
+
+	// Teams from other providers may have no Azure AD group to look up.
+	groupIDs := make([]string, 0, len(team.Groups)+1)
+	for _, id := range append([]string{team.AzureUUID}, team.Groups...) {
+		if len(id) > 0 {
+			groupIDs = append(groupIDs, id)
+		}
+	}
+	if len(groupIDs) == 0 {
+		return false
+	}
+
+	key := username + "/" + team.ID
+	now := time.Now()
+
+	m.mutex.Lock()
+	entry, found := m.cache[key]
+	m.mutex.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.member
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	matches, err := m.check(ctx, username, groupIDs)
+	if err != nil {
+		log.Errorf("azure: while looking up membership of user '%s' in team '%s': %s", username, team.ID, err)
+		return false
+	}
+
+	member := len(matches) > 0
+	log.Debugf("azure: live lookup of user '%s' in team '%s' returned membership=%t", username, team.ID, member)
+
+	m.mutex.Lock()
+	m.cache[key] = membershipEntry{member: member, expires: now.Add(m.ttl)}
+	for k, e := range m.cache {
+		if now.After(e.expires) {
+			delete(m.cache, k)
+		}
+	}
+	m.mutex.Unlock()
+
+	return member
+}
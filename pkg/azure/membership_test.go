@@ -0,0 +1,50 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsMember(t *testing.T) {
+	lookups := 0
+	m := NewMembershipLookup(time.Second, time.Minute)
+	m.check = func(ctx context.Context, username string, groupIDs []string) ([]string, error) {
+		lookups++
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if username == "member@example.com" {
+			return groupIDs[:1], nil
+		}
+		return nil, nil
+	}
+	team := Team{ID: "team", AzureUUID: "group-id"}
+
+	assert.True(t, m.IsMember(context.Background(), "member@example.com", team))
+	assert.True(t, m.IsMember(context.Background(), "member@example.com", team))
+	assert.Equal(t, 1, lookups, "results are cached")
+	assert.False(t, m.IsMember(context.Background(), "other@example.com", team))
+	assert.Equal(t, 2, lookups)
+
+	assert.False(t, m.IsMember(context.Background(), "system:serviceaccount:default:default", team))
+	assert.False(t, m.IsMember(context.Background(), "member@example.com", Team{ID: "ldap-team"}))
+	assert.Equal(t, 2, lookups, "system users and teams without groups are not looked up")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, m.IsMember(ctx, "member@example.com", Team{ID: "other-team", AzureUUID: "other-group-id"}))
+	assert.Equal(t, 3, lookups, "the lookup is abandoned along with the request")
+}
+
+func TestIsMemberGroupIDs(t *testing.T) {
+	m := NewMembershipLookup(time.Second, time.Minute)
+	m.check = func(ctx context.Context, username string, groupIDs []string) ([]string, error) {
+		assert.Equal(t, []string{"extra-group-id"}, groupIDs, "an empty team group is not looked up")
+		return nil, fmt.Errorf("lookup failed")
+	}
+	assert.False(t, m.IsMember(context.Background(), "member@example.com", Team{ID: "team", Groups: []string{"extra-group-id"}}))
+}
@@ -0,0 +1,242 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Group subscriptions may last at most 29 days; they are renewed halfway through their lifetime.
+	subscriptionLifetime = 24 * time.Hour
+	// Delay before retrying a failed subscription request.
+	subscriptionRetry = time.Minute
+)
+
+// Notifications receives Microsoft Graph change notifications for groups, and signals changes
+// to the groups assigned to the team membership application.
+// https://docs.microsoft.com/en-us/graph/webhooks
+type Notifications struct {
+	url         string
+	clientState string
+	changes     chan struct{}
+	mutex       sync.RWMutex
+	watched     map[string]bool
+}
+
+type Subscription struct {
+	ID                 string `json:"id,omitempty"`
+	ChangeType         string `json:"changeType,omitempty"`
+	NotificationURL    string `json:"notificationUrl,omitempty"`
+	Resource           string `json:"resource,omitempty"`
+	ExpirationDateTime string `json:"expirationDateTime"`
+	ClientState        string `json:"clientState,omitempty"`
+}
+
+type changeNotificationList struct {
+	Value []struct {
+		SubscriptionID string `json:"subscriptionId"`
+		ClientState    string `json:"clientState"`
+		ChangeType     string `json:"changeType"`
+		ResourceData   struct {
+			ID string `json:"id"`
+		} `json:"resourceData"`
+	} `json:"value"`
+}
+
+// NewNotifications returns a change notification receiver. Graph delivers notifications to the public
+// notification URL, which must be routed to this receiver. Notifications not carrying the client state are ignored.
+func NewNotifications(notificationURL, clientState string) *Notifications {
+	return &Notifications{
+		url:         notificationURL,
+		clientState: clientState,
+		changes:     make(chan struct{}, 1),
+		watched:     make(map[string]bool),
+	}
+}
+
+// Watch replaces the set of groups whose changes are signaled.
+func (n *Notifications) Watch(groupIDs []string) {
+	watched := make(map[string]bool, len(groupIDs))
+	for _, groupID := range groupIDs {
+		watched[groupID] = true
+	}
+	n.mutex.Lock()
+	n.watched = watched
+	n.mutex.Unlock()
+}
+
+func (n *Notifications) watching(groupID string) bool {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.watched[groupID]
+}
+
+// Changes signals that one or more watched groups have changed.
+func (n *Notifications) Changes() <-chan struct{} {
+	return n.changes
+}
+
+func (n *Notifications) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Graph validates the notification URL by expecting the validation token echoed back.
+	if token := r.URL.Query().Get("validationToken"); len(token) > 0 {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write([]byte(token))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	notifications := &changeNotificationList{}
+	err := json.NewDecoder(r.Body).Decode(notifications)
+	if err != nil {
+		log.Warnf("azure: while decoding change notification: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Notifications are acknowledged regardless of their contents, so that Graph does not redeliver them.
+	w.WriteHeader(http.StatusAccepted)
+
+	changed := false
+	for _, notification := range notifications.Value {
+		if notification.ClientState != n.clientState {
+			log.Warnf("azure: ignoring change notification for subscription '%s' with unexpected client state", notification.SubscriptionID)
+			continue
+		}
+		if n.watching(notification.ResourceData.ID) {
+			log.Debugf("azure: group '%s' %s", notification.ResourceData.ID, notification.ChangeType)
+			changed = true
+		}
+	}
+
+	if changed {
+		select {
+		case n.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe creates a group change subscription and keeps renewing it until the context is done,
+// at which point the subscription is deleted. Failed subscriptions are retried; the regular sync
+// interval still applies, so teams are eventually synchronized even without notifications.
+func (n *Notifications) Subscribe(ctx context.Context) {
+	var id string
+	for {
+		graphAPI := NewGraphAPI(ctx, client(ctx))
+		expiry := time.Now().Add(subscriptionLifetime)
+		delay := subscriptionLifetime / 2
+
+		if len(id) == 0 {
+			subscription, err := graphAPI.CreateSubscription(Subscription{
+				ChangeType:         "updated,deleted",
+				NotificationURL:    n.url,
+				Resource:           "groups",
+				ExpirationDateTime: expiry.UTC().Format(time.RFC3339),
+				ClientState:        n.clientState,
+			})
+			if err == nil {
+				id = subscription.ID
+				log.Infof("azure: subscribed to group change notifications until %s", subscription.ExpirationDateTime)
+			} else if ctx.Err() == nil {
+				log.Errorf("azure: while subscribing to group change notifications: %s", err)
+				delay = subscriptionRetry
+			}
+		} else {
+			err := graphAPI.RenewSubscription(id, expiry)
+			if err != nil && ctx.Err() == nil {
+				log.Errorf("azure: while renewing group change subscription, creating a new one: %s", err)
+				id = ""
+				delay = subscriptionRetry
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if len(id) > 0 {
+				n.unsubscribe(id)
+			}
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (n *Notifications) unsubscribe(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := NewGraphAPI(ctx, client(ctx)).DeleteSubscription(id)
+	if err != nil {
+		log.Warnf("azure: while deleting group change subscription: %s", err)
+		return
+	}
+	log.Infof("azure: unsubscribed from group change notifications")
+}
+
+// Create a change notification subscription. Graph validates the notification URL before returning.
+// https://docs.microsoft.com/en-us/graph/api/subscription-post-subscriptions?view=graph-rest-1.0
+func (g *GraphAPI) CreateSubscription(subscription Subscription) (*Subscription, error) {
+	_, body, err := g.send(http.MethodPost, "https://graph.microsoft.com/v1.0/subscriptions", subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	created := &Subscription{}
+	err = json.Unmarshal(body, created)
+	if err != nil {
+		return nil, err
+	}
+	if len(created.ID) == 0 {
+		return nil, fmt.Errorf("subscription was created without an ID")
+	}
+
+	return created, nil
+}
+
+// Extend the lifetime of a subscription.
+func (g *GraphAPI) RenewSubscription(id string, expiry time.Time) error {
+	_, _, err := g.send(http.MethodPatch, "https://graph.microsoft.com/v1.0/subscriptions/"+url.PathEscape(id), Subscription{
+		ExpirationDateTime: expiry.UTC().Format(time.RFC3339),
+	})
+	return err
+}
+
+func (g *GraphAPI) DeleteSubscription(id string) error {
+	_, _, err := g.send(http.MethodDelete, "https://graph.microsoft.com/v1.0/subscriptions/"+url.PathEscape(id), nil)
+	return err
+}
+
+// Send a request with an optional JSON payload.
+func (g *GraphAPI) send(method, u string, payload interface{}) (*http.Response, []byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(g.ctx)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return g.do(req)
+}
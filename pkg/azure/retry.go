@@ -0,0 +1,94 @@
+package azure
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how failed Microsoft Graph queries are retried.
+// The total time spent is bounded by the context of the query.
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var retryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// SetRetryPolicy configures retries for all subsequent Microsoft Graph queries.
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy = policy
+}
+
+// Exponential backoff, randomized within the upper half of the interval to avoid synchronized retries.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff << uint(attempt)
+	if delay > p.MaxBackoff || delay <= 0 {
+		delay = p.MaxBackoff
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Network errors and server errors are considered transient.
+func transient(response *http.Response) bool {
+	return response == nil || response.StatusCode >= 500
+}
+
+// Throttling is tenant-wide, so all queries are paced after Microsoft Graph responds with 429 Too Many Requests.
+// https://docs.microsoft.com/en-us/graph/throttling
+var throttle = &throttler{}
+
+type throttler struct {
+	mutex sync.Mutex
+	until time.Time
+}
+
+// Postpone all queries until the specified delay has passed.
+func (t *throttler) delay(d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	until := time.Now().Add(d)
+	if until.After(t.until) {
+		t.until = until
+	}
+}
+
+// Block until queries are no longer throttled, or the context is done.
+func (t *throttler) wait(ctx context.Context) error {
+	t.mutex.Lock()
+	d := time.Until(t.until)
+	t.mutex.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Parse the Retry-After header, which is either a number of seconds or a HTTP date.
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	header := response.Header.Get("Retry-After")
+	if len(header) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
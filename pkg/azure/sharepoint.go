@@ -0,0 +1,135 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SharePointConfig describes a SharePoint list containing one team per list item.
+type SharePointConfig struct {
+	// Site ID, or host name and server relative path on the form 'example.sharepoint.com:/sites/teams'.
+	Site string
+	// List ID or display name.
+	List   string
+	Fields SharePointFields
+}
+
+// SharePointFields names the list columns holding team data. Optional columns may be left empty.
+type SharePointFields struct {
+	ID          string
+	Title       string
+	Description string
+	Contact     string
+	Group       string // Azure AD group ID whose members belong to the team
+	Members     string // Comma-separated usernames belonging to the team
+}
+
+// SharePointProvider retrieves teams from a SharePoint list, e.g. one populated by a team registration form.
+type SharePointProvider struct {
+	config SharePointConfig
+}
+
+type listItemList struct {
+	NextLink string `json:"@odata.nextLink"`
+	Value    []struct {
+		Fields map[string]interface{} `json:"fields"`
+	} `json:"value"`
+}
+
+func NewSharePointProvider(config SharePointConfig) *SharePointProvider {
+	return &SharePointProvider{
+		config: config,
+	}
+}
+
+// Retrieve the specified fields of all items in a SharePoint list.
+// https://docs.microsoft.com/en-us/graph/api/listitem-list?view=graph-rest-1.0
+func (g *GraphAPI) ListItems(site, list string, fields []string) ([]map[string]interface{}, error) {
+	// Sites addressed by path must be terminated by a colon before the next path segment.
+	if strings.Contains(site, ":/") {
+		site += ":"
+	}
+
+	queryParams := url.Values{}
+	queryParams.Set("$top", "999")
+	queryParams.Set("$expand", fmt.Sprintf("fields($select=%s)", strings.Join(fields, ",")))
+	nextURL := fmt.Sprintf("https://graph.microsoft.com/v1.0/sites/%s/lists/%s/items?%s", site, url.PathEscape(list), queryParams.Encode())
+
+	items := make([]map[string]interface{}, 0)
+	for page := 1; len(nextURL) != 0; page++ {
+		if page > maxPages {
+			return nil, fmt.Errorf("list items exceed %d pages", maxPages)
+		}
+
+		_, body, err := g.query(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		itemList := &listItemList{}
+		err = json.Unmarshal(body, itemList)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range itemList.Value {
+			items = append(items, item.Fields)
+		}
+		nextURL = itemList.NextLink
+	}
+
+	return items, nil
+}
+
+// Return a list item field as a string. Missing fields are returned as the empty string.
+func field(item map[string]interface{}, name string) string {
+	if len(name) == 0 || item[name] == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(item[name]))
+}
+
+func (p *SharePointProvider) Teams(ctx context.Context) (map[string]Team, error) {
+	graphAPI := NewGraphAPI(ctx, client(ctx))
+	f := p.config.Fields
+
+	columns := make([]string, 0)
+	for _, column := range []string{f.ID, f.Title, f.Description, f.Contact, f.Group, f.Members} {
+		if len(column) > 0 {
+			columns = append(columns, column)
+		}
+	}
+
+	items, err := graphAPI.ListItems(p.config.Site, p.config.List, columns)
+	if err != nil {
+		return nil, fmt.Errorf("while retrieving SharePoint list items: %s", err)
+	}
+
+	teams := make(map[string]Team)
+	for _, item := range items {
+		team := Team{
+			ID:          strings.ToLower(field(item, f.ID)),
+			Title:       field(item, f.Title),
+			Description: field(item, f.Description),
+			Contact:     field(item, f.Contact),
+			AzureUUID:   field(item, f.Group),
+		}
+		for _, member := range strings.Split(field(item, f.Members), ",") {
+			if member = strings.TrimSpace(member); len(member) > 0 {
+				team.Members = append(team.Members, member)
+			}
+		}
+		if !team.Valid() {
+			log.Errorf("sharepoint: invalid team '%s'", team.ID)
+			continue
+		}
+		teams[team.ID] = team
+		log.Debugf("sharepoint: add team '%s' with id '%s'", team.ID, team.AzureUUID)
+	}
+
+	return teams, nil
+}
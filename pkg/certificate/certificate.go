@@ -0,0 +1,148 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Source returns the PEM encoded certificate and key.
+type Source func(ctx context.Context) (certPEM, keyPEM []byte, err error)
+
+// Files reads the certificate and key from disk.
+func Files(certFile, keyFile string) Source {
+	return func(ctx context.Context) ([]byte, []byte, error) {
+		certPEM, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return certPEM, keyPEM, nil
+	}
+}
+
+// Reloader serves a TLS certificate, and replaces it when the certificate or key changes,
+// e.g. when rotated by cert-manager.
+type Reloader struct {
+	name        string
+	source      Source
+	certificate atomic.Value
+	certPEM     []byte
+	keyPEM      []byte
+	changes     chan struct{}
+}
+
+// NewReloader loads the certificate and key from disk, failing if they cannot be used.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	return New(context.Background(), fmt.Sprintf("'%s'", certFile), Files(certFile, keyFile))
+}
+
+// New loads the certificate and key from a source, failing if they cannot be used.
+// The name describes the source in log messages.
+func New(ctx context.Context, name string, source Source) (*Reloader, error) {
+	r := &Reloader{
+		name:    name,
+		source:  source,
+		changes: make(chan struct{}, 1),
+	}
+	certPEM, keyPEM, err := source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while reading certificate and key: %s", err)
+	}
+	err = r.load(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate.Load().(*tls.Certificate), nil
+}
+
+func (r *Reloader) load(certPEM, keyPEM []byte) error {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("while loading certificate and key: %s", err)
+	}
+	r.certificate.Store(&certificate)
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	return nil
+}
+
+// Watch polls the source for changes until the context is done. Polling is used instead of filesystem
+// notifications, because mounted secrets are updated by swapping symbolic links. The current certificate
+// is kept if the new one cannot be loaded, e.g. while only one of the files is written.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		certPEM, keyPEM, err := r.read(ctx, interval)
+		if err != nil {
+			log.Errorf("while checking certificate %s: %s", r.name, err)
+			continue
+		}
+		if bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) {
+			continue
+		}
+		err = r.load(certPEM, keyPEM)
+		if err != nil {
+			log.Errorf("Keeping current certificate: %s", err)
+			continue
+		}
+		log.Infof("Reloaded TLS certificate from %s", r.name)
+		select {
+		case r.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Read from the source, giving up when the next poll is due.
+func (r *Reloader) read(ctx context.Context, timeout time.Duration) ([]byte, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return r.source(ctx)
+}
+
+// Changes signals that the certificate has been reloaded.
+func (r *Reloader) Changes() <-chan struct{} {
+	return r.changes
+}
+
+// Expiry returns the time the current certificate expires.
+func (r *Reloader) Expiry() (time.Time, error) {
+	certificate := r.certificate.Load().(*tls.Certificate)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return leaf.NotAfter, nil
+}
+
+// CABundle returns the PEM encoded certificate that API servers should trust: the last certificate
+// in the chain, which is the certificate itself if it is self-signed.
+func (r *Reloader) CABundle() []byte {
+	chain := r.certificate.Load().(*tls.Certificate).Certificate
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[len(chain)-1]})
+}
@@ -0,0 +1,122 @@
+package certificate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a self-signed certificate with the specified serial number and modification time.
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64, modified time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "tobac"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	assert.NoError(t, os.Chtimes(certFile, modified, modified))
+	assert.NoError(t, os.Chtimes(keyFile, modified, modified))
+}
+
+func serial(t *testing.T, r *Reloader) int64 {
+	certificate, err := r.GetCertificate(nil)
+	assert.NoError(t, err)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.NoError(t, err)
+	return parsed.SerialNumber.Int64()
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	now := time.Now()
+
+	writeCertificate(t, certFile, keyFile, 1, now.Add(-time.Hour))
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), serial(t, reloader))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, time.Millisecond)
+
+	// A key that does not match the certificate is not loaded.
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(1), serial(t, reloader))
+
+	writeCertificate(t, certFile, keyFile, 2, now)
+	assert.Eventually(t, func() bool {
+		return serial(t, reloader) == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestCABundleOfSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	expected, err := ioutil.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, reloader.CABundle())
+}
+
+func TestGenerateRequest(t *testing.T) {
+	keyPEM, requestPEM, err := GenerateRequest([]string{"tobac.nais.svc", "tobac.nais.svc.cluster.local"})
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(requestPEM)
+	assert.Equal(t, "CERTIFICATE REQUEST", block.Type)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(t, err)
+	assert.NoError(t, request.CheckSignature())
+	assert.Equal(t, "tobac.nais.svc", request.Subject.CommonName)
+	assert.Equal(t, []string{"tobac.nais.svc", "tobac.nais.svc.cluster.local"}, request.DNSNames)
+
+	block, _ = pem.Decode(keyPEM)
+	assert.Equal(t, "EC PRIVATE KEY", block.Type)
+}
+
+func TestValid(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	assert.False(t, Valid(certFile, keyFile, 0))
+
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+	assert.True(t, Valid(certFile, keyFile, time.Minute))
+	assert.False(t, Valid(certFile, keyFile, 2*time.Hour))
+
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	expiry, err := reloader.Expiry()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute)
+}
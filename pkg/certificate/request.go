@@ -0,0 +1,61 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+)
+
+// GenerateRequest generates a private key and a PEM encoded certificate signing request for a serving certificate.
+func GenerateRequest(dnsNames []string) (keyPEM, requestPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsNames[0]},
+		DNSNames: dnsNames,
+	}
+	request, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	requestPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request})
+	return keyPEM, requestPEM, nil
+}
+
+// Valid returns true if the certificate and key files can be loaded, and the certificate is valid for at least the specified duration.
+func Valid(certFile, keyFile string, remaining time.Duration) bool {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return false
+	}
+	return time.Now().Add(remaining).Before(leaf.NotAfter)
+}
+
+// Write stores the certificate and key, so that they can be served by a Reloader.
+func Write(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	err := ioutil.WriteFile(keyFile, keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, certPEM, 0644)
+}
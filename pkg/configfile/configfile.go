@@ -0,0 +1,155 @@
+package configfile
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// Load reads a YAML or JSON configuration file, returning a value for each flag it sets. Keys are flag
+// names, and sections are joined to their keys with a dash, so that these files are equivalent:
+//
+//	tls-min-version: "1.3"
+//
+//	tls:
+//	  min-version: "1.3"
+//
+// Lists are given as YAML lists.
+//
+// Named sets of settings can be given in the 'profiles' section. The settings of the selected profile
+// override the other settings in the file, while other profiles are ignored:
+//
+//	cluster-admins: [ops]
+//	profiles:
+//	  prod:
+//	    cluster-admins: [ops, security]
+func Load(path, profile string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, profile)
+}
+
+// Section holding the profiles.
+const profilesKey = "profiles"
+
+// Parse decodes a YAML or JSON configuration file, applying the settings of the profile, if not empty.
+func Parse(data []byte, profile string) (map[string]string, error) {
+	document := make(map[string]interface{})
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, ok := document[profilesKey].(map[string]interface{})
+	if _, found := document[profilesKey]; found && !ok {
+		return nil, fmt.Errorf("'%s' must be a section", profilesKey)
+	}
+	delete(document, profilesKey)
+
+	values := make(map[string]string)
+	err = flatten("", document, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profile) == 0 {
+		return values, nil
+	}
+	settings, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("profile '%s' is not defined", profile)
+	}
+	err = flatten("", settings, values)
+	if err != nil {
+		return nil, fmt.Errorf("in profile '%s': %s", profile, err)
+	}
+	return values, nil
+}
+
+func flatten(prefix string, section map[string]interface{}, values map[string]string) error {
+	for key, value := range section {
+		name := prefix + key
+		switch v := value.(type) {
+		case map[string]interface{}:
+			err := flatten(name+"-", v, values)
+			if err != nil {
+				return err
+			}
+		case []interface{}:
+			list := make([]string, 0, len(v))
+			for _, item := range v {
+				if _, ok := item.(map[string]interface{}); ok {
+					return fmt.Errorf("'%s' must be a list of values", name)
+				}
+				list = append(list, scalar(item))
+			}
+			joined, err := join(list)
+			if err != nil {
+				return err
+			}
+			values[name] = joined
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = scalar(v)
+		}
+	}
+	return nil
+}
+
+// Numbers are decoded as floats, which must not be formatted with exponents.
+func scalar(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Join list values the way list flags split them.
+func join(list []string) (string, error) {
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	err := writer.Write(list)
+	if err != nil {
+		return "", err
+	}
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n"), writer.Error()
+}
+
+// Apply sets flags from configuration values, except flags given on the command line, which take precedence.
+// Unknown keys are refused, so that misspelled settings are not silently ignored.
+func Apply(flags *flag.FlagSet, values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting '%s'", name)
+		}
+	}
+
+	for _, name := range names {
+		f := flags.Lookup(name)
+		if f.Changed {
+			continue
+		}
+		err := f.Value.Set(values[name])
+		if err != nil {
+			return fmt.Errorf("invalid value for '%s': %s", name, err)
+		}
+	}
+	return nil
+}
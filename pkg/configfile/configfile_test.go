@@ -0,0 +1,89 @@
+package configfile
+
+import (
+	"testing"
+
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+log-level: debug
+tls:
+  min-version: "1.3"
+  cipher-suites:
+  - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+  - TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+azure-concurrency: 4
+audit-log-max-size: 1000000
+`
+
+func TestParse(t *testing.T) {
+	values, err := Parse([]byte(testConfig), "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"log-level":          "debug",
+		"tls-min-version":    "1.3",
+		"tls-cipher-suites":  "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"azure-concurrency":  "4",
+		"audit-log-max-size": "1000000",
+	}, values)
+}
+
+func TestFlagsOverrideFile(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	logLevel := flags.String("log-level", "info", "")
+	minVersion := flags.String("tls-min-version", "1.2", "")
+	cipherSuites := flags.StringSlice("tls-cipher-suites", nil, "")
+	concurrency := flags.Int("azure-concurrency", 1, "")
+	flags.Int("audit-log-max-size", 100, "")
+	assert.NoError(t, flags.Parse([]string{"--log-level=warn"}))
+
+	values, err := Parse([]byte(testConfig), "")
+	assert.NoError(t, err)
+	assert.NoError(t, Apply(flags, values))
+
+	assert.Equal(t, "warn", *logLevel)
+	assert.Equal(t, "1.3", *minVersion)
+	assert.Equal(t, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, *cipherSuites)
+	assert.Equal(t, 4, *concurrency)
+}
+
+func TestUnknownSetting(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	values, err := Parse([]byte("log-levle: debug"), "")
+	assert.NoError(t, err)
+	assert.EqualError(t, Apply(flags, values), "unknown setting 'log-levle'")
+}
+
+const testProfiles = `
+log-level: info
+cluster-admins: [ops]
+profiles:
+  dev:
+    log-level: debug
+  prod:
+    cluster-admins: [ops, security]
+    tls:
+      min-version: "1.3"
+`
+
+func TestProfile(t *testing.T) {
+	values, err := Parse([]byte(testProfiles), "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"log-level":      "info",
+		"cluster-admins": "ops",
+	}, values)
+
+	values, err = Parse([]byte(testProfiles), "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"log-level":       "info",
+		"cluster-admins":  "ops,security",
+		"tls-min-version": "1.3",
+	}, values)
+
+	_, err = Parse([]byte(testProfiles), "staging")
+	assert.EqualError(t, err, "profile 'staging' is not defined")
+}
@@ -0,0 +1,226 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+)
+
+const pageSize = 100
+
+const teamsQuery = `query Teams($offset: Int, $limit: Int) {
+  teams(offset: $offset, limit: $limit) {
+    nodes {
+      slug
+      purpose
+      slackChannel
+      azureGroupID
+      members(limit: $limit) {
+        nodes {
+          user {
+            email
+          }
+        }
+        pageInfo {
+          hasNextPage
+        }
+      }
+    }
+    pageInfo {
+      hasNextPage
+    }
+  }
+}`
+
+// Retrieves the members of teams with more members than fit in the team list.
+const membersQuery = `query TeamMembers($slug: Slug!, $offset: Int, $limit: Int) {
+  team(slug: $slug) {
+    members(offset: $offset, limit: $limit) {
+      nodes {
+        user {
+          email
+        }
+      }
+      pageInfo {
+        hasNextPage
+      }
+    }
+  }
+}`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+type graphQLResponse struct {
+	Errors []graphQLError `json:"errors"`
+}
+
+type pageInfo struct {
+	HasNextPage bool `json:"hasNextPage"`
+}
+
+type teamsResponse struct {
+	Data struct {
+		Teams struct {
+			Nodes    []Team   `json:"nodes"`
+			PageInfo pageInfo `json:"pageInfo"`
+		} `json:"teams"`
+	} `json:"data"`
+}
+
+type membersResponse struct {
+	Data struct {
+		Team struct {
+			Members Members `json:"members"`
+		} `json:"team"`
+	} `json:"data"`
+}
+
+type Team struct {
+	Slug         string  `json:"slug"`
+	Purpose      string  `json:"purpose"`
+	SlackChannel string  `json:"slackChannel"`
+	AzureGroupID string  `json:"azureGroupID"`
+	Members      Members `json:"members"`
+}
+
+type Members struct {
+	Nodes []struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+	} `json:"nodes"`
+	PageInfo pageInfo `json:"pageInfo"`
+}
+
+// Provider retrieves teams from the nais teams-backend GraphQL API.
+type Provider struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+// New returns a provider querying the GraphQL endpoint at the specified URL, authenticating with an API key.
+func New(client *http.Client, url, apiKey string) *Provider {
+	return &Provider{
+		client: client,
+		url:    url,
+		apiKey: apiKey,
+	}
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	teams := make(map[string]azure.Team)
+
+	for offset := 0; ; offset += pageSize {
+		response := &teamsResponse{}
+		err := p.query(ctx, teamsQuery, map[string]interface{}{"offset": offset, "limit": pageSize}, response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, consoleTeam := range response.Data.Teams.Nodes {
+			team := azure.Team{
+				AzureUUID:   consoleTeam.AzureGroupID,
+				ID:          strings.ToLower(consoleTeam.Slug),
+				Title:       consoleTeam.Slug,
+				Description: consoleTeam.Purpose,
+				Contact:     consoleTeam.SlackChannel,
+			}
+			team.Members, err = p.members(ctx, consoleTeam.Slug, consoleTeam.Members)
+			if err != nil {
+				return nil, fmt.Errorf("while retrieving members of team '%s': %s", consoleTeam.Slug, err)
+			}
+			if !team.Valid() {
+				log.Errorf("console: invalid team '%s'", consoleTeam.Slug)
+				continue
+			}
+			teams[team.ID] = team
+			log.Debugf("console: add team '%s' with id '%s'", team.ID, team.AzureUUID)
+		}
+
+		if !response.Data.Teams.PageInfo.HasNextPage {
+			break
+		}
+	}
+
+	return teams, nil
+}
+
+// Returns the email addresses of the members of a team, retrieving the pages following the first one.
+func (p *Provider) members(ctx context.Context, slug string, first Members) ([]string, error) {
+	var emails []string
+	page := first
+	for offset := pageSize; ; offset += pageSize {
+		for _, member := range page.Nodes {
+			emails = append(emails, member.User.Email)
+		}
+		if !page.PageInfo.HasNextPage {
+			return emails, nil
+		}
+
+		response := &membersResponse{}
+		err := p.query(ctx, membersQuery, map[string]interface{}{"slug": slug, "offset": offset, "limit": pageSize}, response)
+		if err != nil {
+			return nil, err
+		}
+		page = response.Data.Team.Members
+	}
+}
+
+// Runs a GraphQL query, decoding its data into result.
+func (p *Provider) query(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	payload, err := json.Marshal(graphQLRequest{
+		Query:     query,
+		Variables: variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	response, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", response.Status, string(body))
+	}
+
+	errors := &graphQLResponse{}
+	err = json.Unmarshal(body, errors)
+	if err != nil {
+		return err
+	}
+	if len(errors.Errors) > 0 {
+		return fmt.Errorf("graphql: %s", errors.Errors[0].Message)
+	}
+
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,69 @@
+package console_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/tobac/pkg/console"
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	Variables struct {
+		Slug   string `json:"slug"`
+		Offset int    `json:"offset"`
+		Limit  int    `json:"limit"`
+	} `json:"variables"`
+}
+
+// Two pages of teams, the second one holding a team without members or group.
+var pages = map[int]string{
+	0:   `{"data":{"teams":{"nodes":[{"slug":"Team-A","purpose":"Purpose","slackChannel":"#team-a","azureGroupID":"uuid","members":{"nodes":[{"user":{"email":"user@example.com"}}],"pageInfo":{"hasNextPage":true}}}],"pageInfo":{"hasNextPage":true}}}}`,
+	100: `{"data":{"teams":{"nodes":[{"slug":"team-b","azureGroupID":"uuid-b"},{"slug":"invalid"}],"pageInfo":{"hasNextPage":false}}}}`,
+}
+
+// The second page of members of Team-A.
+const memberPage = `{"data":{"team":{"members":{"nodes":[{"user":{"email":"other@example.com"}}],"pageInfo":{"hasNextPage":false}}}}}`
+
+func TestTeams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		req := &request{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		assert.Equal(t, 100, req.Variables.Limit)
+		if len(req.Variables.Slug) > 0 {
+			assert.Equal(t, "Team-A", req.Variables.Slug)
+			assert.Equal(t, 100, req.Variables.Offset)
+			w.Write([]byte(memberPage))
+			return
+		}
+		w.Write([]byte(pages[req.Variables.Offset]))
+	}))
+	defer server.Close()
+
+	teams, err := console.New(server.Client(), server.URL, "secret").Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, teams, 2, "teams are retrieved from every page, skipping invalid teams")
+
+	team := teams["team-a"]
+	assert.Equal(t, "uuid", team.AzureUUID)
+	assert.Equal(t, "Purpose", team.Description)
+	assert.Equal(t, "#team-a", team.Contact)
+	assert.Equal(t, []string{"user@example.com", "other@example.com"}, team.Members, "members are retrieved from every page")
+	assert.Equal(t, "uuid-b", teams["team-b"].AzureUUID)
+}
+
+func TestTeamsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":[{"message":"unauthorized"}]}`))
+	}))
+	defer server.Close()
+
+	_, err := console.New(server.Client(), server.URL, "wrong").Teams(context.Background())
+	assert.EqualError(t, err, "graphql: unauthorized")
+}
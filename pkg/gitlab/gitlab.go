@@ -0,0 +1,154 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider retrieves teams from GitLab. Every direct subgroup of the root group is a team,
+// and members of nested subgroups are flattened into the team they descend from.
+type Provider struct {
+	client           *http.Client
+	baseURL          string
+	token            string
+	rootGroup        string
+	usernameTemplate string
+}
+
+type Group struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	FullPath    string `json:"full_path"`
+	Description string `json:"description"`
+}
+
+type Member struct {
+	Username string `json:"username"`
+	State    string `json:"state"`
+}
+
+// New returns a GitLab team provider. The username template is used to translate
+// GitLab usernames into Kubernetes usernames, where %s is replaced by the GitLab username.
+func New(client *http.Client, baseURL, token, rootGroup, usernameTemplate string) *Provider {
+	return &Provider{
+		client:           client,
+		baseURL:          strings.TrimSuffix(baseURL, "/"),
+		token:            token,
+		rootGroup:        rootGroup,
+		usernameTemplate: usernameTemplate,
+	}
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	teamGroups := make([]Group, 0)
+	err := p.list(ctx, fmt.Sprintf("/groups/%s/subgroups", url.PathEscape(p.rootGroup)), &teamGroups)
+	if err != nil {
+		return nil, fmt.Errorf("list team groups: %s", err)
+	}
+
+	teams := make(map[string]azure.Team)
+	for _, teamGroup := range teamGroups {
+		team, err := p.team(ctx, teamGroup)
+		if err != nil {
+			return nil, fmt.Errorf("team '%s': %s", teamGroup.FullPath, err)
+		}
+		if !team.Valid() {
+			log.Errorf("gitlab: invalid team '%s'", teamGroup.FullPath)
+			continue
+		}
+		teams[team.ID] = *team
+		log.Debugf("gitlab: add team '%s' with %d groups and %d members", team.ID, len(team.Groups), len(team.Members))
+	}
+
+	return teams, nil
+}
+
+// Build a team from a group, flattening all of its subgroups.
+func (p *Provider) team(ctx context.Context, teamGroup Group) (*azure.Team, error) {
+	descendants := make([]Group, 0)
+	err := p.list(ctx, fmt.Sprintf("/groups/%d/descendant_groups", teamGroup.ID), &descendants)
+	if err != nil {
+		return nil, err
+	}
+
+	team := &azure.Team{
+		ID:          strings.ToLower(teamGroup.Path),
+		Title:       teamGroup.Name,
+		Description: teamGroup.Description,
+	}
+
+	usernames := make(map[string]bool)
+	for _, group := range append([]Group{teamGroup}, descendants...) {
+		team.Groups = append(team.Groups, group.FullPath)
+
+		members := make([]Member, 0)
+		err = p.list(ctx, fmt.Sprintf("/groups/%d/members", group.ID), &members)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.State != "active" || usernames[member.Username] {
+				continue
+			}
+			usernames[member.Username] = true
+			team.Members = append(team.Members, fmt.Sprintf(p.usernameTemplate, member.Username))
+		}
+	}
+
+	return team, nil
+}
+
+// Retrieve all pages of a list endpoint, appending the results to the slice pointed to by v.
+func (p *Provider) list(ctx context.Context, path string, v interface{}) error {
+	page := "1"
+	results := make([]json.RawMessage, 0)
+
+	for len(page) > 0 {
+		queryParams := url.Values{}
+		queryParams.Set("per_page", "100")
+		queryParams.Set("page", page)
+
+		req, err := http.NewRequest(http.MethodGet, p.baseURL+"/api/v4"+path+"?"+queryParams.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("PRIVATE-TOKEN", p.token)
+
+		response, err := p.client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if response.StatusCode > 299 {
+			return fmt.Errorf("%s: %s", response.Status, string(body))
+		}
+
+		items := make([]json.RawMessage, 0)
+		err = json.Unmarshal(body, &items)
+		if err != nil {
+			return err
+		}
+		results = append(results, items...)
+		page = response.Header.Get("X-Next-Page")
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,88 @@
+package gitlab_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/tobac/pkg/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// Paged responses of the GitLab API, by path and page number.
+var pages = map[string]map[string]interface{}{
+	"/api/v4/groups/teams/subgroups": {
+		"1": []gitlab.Group{{ID: 1, Name: "Team A", Path: "Team-A", FullPath: "teams/Team-A"}},
+		"2": []gitlab.Group{{ID: 2, Name: "Empty", Path: "empty", FullPath: "teams/empty"}},
+	},
+	"/api/v4/groups/1/descendant_groups": {
+		"1": []gitlab.Group{{ID: 3, Path: "backend", FullPath: "teams/Team-A/backend"}},
+	},
+	"/api/v4/groups/2/descendant_groups": {
+		"1": []gitlab.Group{},
+	},
+	"/api/v4/groups/1/members": {
+		"1": []gitlab.Member{{Username: "alice", State: "active"}, {Username: "blocked", State: "blocked"}},
+		"2": []gitlab.Member{{Username: "bob", State: "active"}},
+	},
+	"/api/v4/groups/2/members": {
+		"1": []gitlab.Member{},
+	},
+	"/api/v4/groups/3/members": {
+		"1": []gitlab.Member{{Username: "alice", State: "active"}, {Username: "carol", State: "active"}},
+	},
+}
+
+func serve(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		assert.Equal(t, "100", r.URL.Query().Get("per_page"))
+
+		page := r.URL.Query().Get("page")
+		results, ok := pages[r.URL.Path][page]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if _, ok := pages[r.URL.Path][nextPage(page)]; ok {
+			w.Header().Set("X-Next-Page", nextPage(page))
+		}
+		json.NewEncoder(w).Encode(results)
+	}))
+}
+
+func nextPage(page string) string {
+	if page == "1" {
+		return "2"
+	}
+	return ""
+}
+
+func TestTeams(t *testing.T) {
+	server := serve(t)
+	defer server.Close()
+
+	provider := gitlab.New(server.Client(), server.URL+"/", "secret", "teams", "%s@example.com")
+	teams, err := provider.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, teams, 2, "subgroups are retrieved from every page")
+
+	team := teams["team-a"]
+	assert.Equal(t, "Team A", team.Title)
+	assert.Equal(t, []string{"teams/Team-A", "teams/Team-A/backend"}, team.Groups)
+	assert.Equal(t, []string{"alice@example.com", "bob@example.com", "carol@example.com"}, team.Members, "members of descendant groups are flattened, skipping inactive and duplicate members")
+
+	assert.Equal(t, []string{"teams/empty"}, teams["empty"].Groups)
+	assert.Empty(t, teams["empty"].Members)
+}
+
+func TestTeamsError(t *testing.T) {
+	server := serve(t)
+	defer server.Close()
+
+	provider := gitlab.New(server.Client(), server.URL, "secret", "unknown", "%s")
+	_, err := provider.Teams(context.Background())
+	assert.Error(t, err)
+}
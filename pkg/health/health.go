@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Check returns an error if a dependency is unhealthy.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single check.
+type Result struct {
+	Healthy bool    `json:"healthy"`
+	Error   string  `json:"error,omitempty"`
+	Latency float64 `json:"latencySeconds"`
+}
+
+// Report is the outcome of all checks.
+type Report struct {
+	Healthy bool              `json:"healthy"`
+	Checked time.Time         `json:"checked"`
+	Checks  map[string]Result `json:"checks"`
+}
+
+// Deep runs live checks of dependencies. Results are cached, so that frequent requests
+// do not put load on the dependencies.
+type Deep struct {
+	checks  map[string]Check
+	ttl     time.Duration
+	timeout time.Duration
+	mutex   sync.Mutex
+	report  *Report
+}
+
+// New returns a deep health check running the named checks, each bounded by the timeout.
+// Results are reused for the duration of the TTL.
+func New(checks map[string]Check, ttl, timeout time.Duration) *Deep {
+	return &Deep{
+		checks:  checks,
+		ttl:     ttl,
+		timeout: timeout,
+	}
+}
+
+// Report returns the cached report, or runs all checks concurrently if it has expired.
+// Concurrent callers wait for the same run.
+func (d *Deep) Report(ctx context.Context) Report {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.report != nil && time.Since(d.report.Checked) < d.ttl {
+		return *d.report
+	}
+
+	report := &Report{
+		Healthy: true,
+		Checked: time.Now(),
+		Checks:  make(map[string]Result, len(d.checks)),
+	}
+	type namedResult struct {
+		name   string
+		result Result
+	}
+	results := make(chan namedResult, len(d.checks))
+
+	for name, check := range d.checks {
+		go func(name string, check Check) {
+			ctx, cancel := context.WithTimeout(ctx, d.timeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			result := Result{Healthy: err == nil, Latency: time.Since(start).Seconds()}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results <- namedResult{name, result}
+		}(name, check)
+	}
+
+	failed := make([]string, 0)
+	for range d.checks {
+		r := <-results
+		report.Checks[r.name] = r.result
+		if !r.result.Healthy {
+			report.Healthy = false
+			failed = append(failed, r.name)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		log.Warnf("Deep health check failed for %v", failed)
+	}
+
+	d.report = report
+	return *report
+}
+
+// ServeHTTP responds with the report, with status 503 if any check failed.
+func (d *Deep) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := d.Report(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Errorf("while sending health report: %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportIsCached(t *testing.T) {
+	calls := 0
+	deep := New(map[string]Check{
+		"kubernetes": func(ctx context.Context) error {
+			calls++
+			return nil
+		},
+		"graph": func(ctx context.Context) error {
+			return fmt.Errorf("unreachable")
+		},
+	}, time.Minute, time.Second)
+
+	recorder := httptest.NewRecorder()
+	deep.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz/deep", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	report := deep.Report(context.Background())
+	assert.False(t, report.Healthy)
+	assert.True(t, report.Checks["kubernetes"].Healthy)
+	assert.Equal(t, "unreachable", report.Checks["graph"].Error)
+	assert.Equal(t, 1, calls)
+}
+
+func TestCheckTimeout(t *testing.T) {
+	deep := New(map[string]Check{
+		"slow": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, 0, time.Millisecond)
+
+	report := deep.Report(context.Background())
+	assert.False(t, report.Healthy)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
@@ -0,0 +1,53 @@
+package kubeclient
+
+import (
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Cluster is a client for an additional Kubernetes cluster, for instances deciding requests from several clusters.
+// Lookups through a Cluster use its own typed and metadata clients, and bypass the caches, which only hold
+// objects of the cluster configured with SetMetadataClient, SetTypedClient and the cache setters.
+type Cluster struct {
+	*Client
+	metadata *MetadataClient
+	typed    *TypedClient
+}
+
+// NewCluster returns a client for the cluster reached with the specified configuration.
+// Built-in kinds are looked up by a typed client if typed is true.
+func NewCluster(config *rest.Config, typed bool) (*Cluster, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := NewMetadataClient(config)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{
+		Client:   client,
+		metadata: metadata,
+	}
+	if typed {
+		cluster.typed, err = NewTypedClient(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cluster, nil
+}
+
+// Returns true if the client is for an additional cluster, whose objects are not cached.
+func additionalCluster(client dynamic.Interface) bool {
+	_, ok := client.(*Cluster)
+	return ok
+}
+
+// Returns the typed and metadata clients to use along with a dynamic client, either of which may be nil.
+func lookupClients(client dynamic.Interface) (*TypedClient, *MetadataClient) {
+	if cluster, ok := client.(*Cluster); ok {
+		return cluster.typed, cluster.metadata
+	}
+	return typedClient, metadataClient
+}
@@ -0,0 +1,28 @@
+package kubeclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestCluster(t *testing.T) {
+	config := &rest.Config{Host: "https://cluster.example.com"}
+	cluster, err := NewCluster(config, true)
+	assert.NoError(t, err)
+	client, err := dynamic.NewForConfig(config)
+	assert.NoError(t, err)
+
+	assert.True(t, additionalCluster(cluster))
+	assert.False(t, additionalCluster(client))
+
+	typed, metadata := lookupClients(cluster)
+	assert.Equal(t, cluster.typed, typed)
+	assert.Equal(t, cluster.metadata, metadata)
+
+	typed, metadata = lookupClients(client)
+	assert.Equal(t, typedClient, typed, "other clients use the clients of the default cluster")
+	assert.Equal(t, metadataClient, metadata)
+}
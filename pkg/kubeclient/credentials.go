@@ -0,0 +1,137 @@
+package kubeclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nais/tobac/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+)
+
+// Interval between checks of the CA bundle file for changes.
+const caReloadInterval = time.Minute
+
+// ReloadCredentials makes clients built from the configuration pick up a rotated CA bundle file, such as the
+// service account CA of the in-cluster configuration. Rotated service account tokens are already picked up
+// by client-go. Requests refused by the API server as unauthorized are counted, so that lost credentials
+// do not go unnoticed. The CA bundle is not reloaded for configurations using client certificates or
+// skipping TLS verification.
+func ReloadCredentials(config *rest.Config) error {
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &authFailureTransport{base: rt}
+	}
+
+	tlsConfig := config.TLSClientConfig
+	if len(tlsConfig.CAFile) == 0 || len(tlsConfig.CAData) > 0 || tlsConfig.Insecure || config.Transport != nil ||
+		len(tlsConfig.CertFile) > 0 || len(tlsConfig.CertData) > 0 {
+		return nil
+	}
+
+	transport, err := newCAReloadingTransport(tlsConfig.CAFile, tlsConfig.ServerName)
+	if err != nil {
+		return err
+	}
+	config.Transport = transport
+	config.TLSClientConfig = rest.TLSClientConfig{}
+	return nil
+}
+
+// Counts responses refusing requests for lacking valid credentials.
+type authFailureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authFailureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		metrics.KubernetesAuthFailures.Inc()
+		log.Debugf("Kubernetes API server refused %s %s as unauthorized", req.Method, req.URL.Path)
+	}
+	return resp, err
+}
+
+// Replaces its transport whenever the CA bundle file changes, checking at most once per reload interval.
+type caReloadingTransport struct {
+	caFile     string
+	serverName string
+	mutex      sync.Mutex
+	transport  *http.Transport
+	modified   time.Time
+	checked    time.Time
+}
+
+func newCAReloadingTransport(caFile, serverName string) (*caReloadingTransport, error) {
+	t := &caReloadingTransport{
+		caFile:     caFile,
+		serverName: serverName,
+	}
+	err := t.reload(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Build a new transport trusting the current CA bundle, if it has changed.
+func (t *caReloadingTransport) reload(now time.Time) error {
+	t.checked = now
+	info, err := os.Stat(t.caFile)
+	if err != nil {
+		return err
+	}
+	if t.transport != nil && info.ModTime().Equal(t.modified) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(t.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in CA bundle '%s'", t.caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 25
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		ServerName: t.serverName,
+	}
+
+	if t.transport != nil {
+		log.Infof("Reloaded Kubernetes API server CA bundle from '%s'", t.caFile)
+		t.transport.CloseIdleConnections()
+	}
+	t.transport = transport
+	t.modified = info.ModTime()
+	return nil
+}
+
+func (t *caReloadingTransport) current() *http.Transport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(t.checked) >= caReloadInterval {
+		err := t.reload(now)
+		if err != nil {
+			log.Errorf("while reloading Kubernetes API server CA bundle: %s", err)
+		}
+	}
+	return t.transport
+}
+
+func (t *caReloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(req)
+}
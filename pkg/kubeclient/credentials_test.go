@@ -0,0 +1,93 @@
+package kubeclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nais/tobac/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+// Start a TLS server with its own self-signed certificate, unlike servers sharing the httptest certificate.
+func newTLSServer(t *testing.T, handler http.Handler) *httptest.Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	return server
+}
+
+// Write the certificate of a test server as a CA bundle.
+func writeCA(t *testing.T, path string, server *httptest.Server, modified time.Time) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+	assert.NoError(t, os.Chtimes(path, modified, modified))
+}
+
+func TestReloadCredentials(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unauthorized" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	first := httptest.NewTLSServer(handler)
+	defer first.Close()
+	second := newTLSServer(t, handler)
+	defer second.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	now := time.Now()
+	writeCA(t, caFile, first, now.Add(-time.Hour))
+
+	config := &rest.Config{Host: first.URL, TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}}
+	assert.NoError(t, ReloadCredentials(config))
+	transport, ok := config.Transport.(*caReloadingTransport)
+	assert.True(t, ok)
+	client := &http.Client{Transport: config.WrapTransport(config.Transport)}
+
+	_, err := client.Get(first.URL)
+	assert.NoError(t, err)
+	_, err = client.Get(second.URL)
+	assert.Error(t, err, "servers not in the CA bundle are not trusted")
+
+	writeCA(t, caFile, second, now)
+	transport.checked = time.Time{}
+	_, err = client.Get(second.URL)
+	assert.NoError(t, err, "the rotated CA bundle is trusted")
+
+	failures := testutil.ToFloat64(metrics.KubernetesAuthFailures)
+	_, err = client.Get(second.URL + "/unauthorized")
+	assert.NoError(t, err)
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.KubernetesAuthFailures))
+}
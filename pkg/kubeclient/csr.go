@@ -0,0 +1,93 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// LegacySigner is the signer used by the v1beta1 certificates API when no signer is specified.
+const LegacySigner = "kubernetes.io/legacy-unknown"
+
+var (
+	csrResourceV1      = schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"}
+	csrResourceV1beta1 = schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1beta1", Resource: "certificatesigningrequests"}
+)
+
+// CertificateSigningRequest is a request for a serving certificate submitted to the Kubernetes certificates API.
+type CertificateSigningRequest struct {
+	client   *ResourceClient
+	resource schema.GroupVersionResource
+	name     string
+}
+
+// SubmitCertificateSigningRequest replaces any existing request with the same name. Requests for the legacy signer
+// use the v1beta1 API, as the v1 API does not support it.
+func SubmitCertificateSigningRequest(ctx context.Context, client dynamic.Interface, name, signerName string, request []byte) (*CertificateSigningRequest, error) {
+	resource := csrResourceV1
+	if signerName == LegacySigner {
+		resource = csrResourceV1beta1
+	}
+	resources, err := resourceClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resources.Delete(ctx, resource, "", name)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("while deleting previous certificate signing request: %s", err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(resource.GroupVersion().String())
+	obj.SetKind("CertificateSigningRequest")
+	obj.SetName(name)
+	obj.Object["spec"] = map[string]interface{}{
+		"request":    base64.StdEncoding.EncodeToString(request),
+		"signerName": signerName,
+		"usages":     []interface{}{"digital signature", "key encipherment", "server auth"},
+	}
+
+	_, err = resources.Create(ctx, resource, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Submitted certificate signing request '%s'; approve it with 'kubectl certificate approve %s'", name, name)
+
+	return &CertificateSigningRequest{
+		client:   resources,
+		resource: resource,
+		name:     name,
+	}, nil
+}
+
+// Certificate returns the PEM encoded issued certificate, or nil if the request has not been issued yet.
+// An error is returned if the request has been denied or has failed.
+func (r *CertificateSigningRequest) Certificate(ctx context.Context) ([]byte, error) {
+	obj, err := r.client.Get(ctx, r.resource, "", r.name)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, _ := c.(map[string]interface{})
+		switch condition["type"] {
+		case "Denied", "Failed":
+			return nil, fmt.Errorf("certificate signing request '%s' %s: %v", r.name, condition["type"], condition["message"])
+		}
+	}
+
+	encoded, _, _ := unstructured.NestedString(obj.Object, "status", "certificate")
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
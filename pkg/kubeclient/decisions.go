@@ -0,0 +1,164 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nais/tobac/pkg/audit"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var admissionDecisionResource = schema.GroupVersionResource{
+	Group:    "tobac.nais.io",
+	Version:  "v1alpha1",
+	Resource: "admissiondecisions",
+}
+
+// Labels set on every AdmissionDecision, so that they can be selected by decision and cleaned up.
+const (
+	decisionManagedByLabel = "app.kubernetes.io/managed-by"
+	decisionManagedBy      = "tobac"
+	decisionLabel          = "tobac.nais.io/decision"
+)
+
+// Number of decisions waiting to be written before further decisions are dropped.
+const decisionQueueSize = 1000
+
+// Timeout for writing a single decision, or for a single cleanup pass.
+const decisionTimeout = 10 * time.Second
+
+// DecisionRecorder stores admission decisions as AdmissionDecision resources in a namespace,
+// and deletes them once they are older than the retention period.
+type DecisionRecorder struct {
+	client    dynamic.Interface
+	namespace string
+	retention time.Duration
+	queue     chan audit.Record
+}
+
+func NewDecisionRecorder(client dynamic.Interface, namespace string, retention time.Duration) *DecisionRecorder {
+	return &DecisionRecorder{
+		client:    client,
+		namespace: namespace,
+		retention: retention,
+		queue:     make(chan audit.Record, decisionQueueSize),
+	}
+}
+
+// Record queues a decision for writing, without waiting for the API server.
+// Returns false if the queue is full and the decision was dropped.
+func (r *DecisionRecorder) Record(record audit.Record) bool {
+	select {
+	case r.queue <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run writes queued decisions, and deletes expired decisions every tenth of the retention period,
+// until the context is done.
+func (r *DecisionRecorder) Run(ctx context.Context) {
+	cleanup := time.NewTicker(r.retention / 10)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-r.queue:
+			err := r.create(ctx, record)
+			if err != nil {
+				log.Errorf("while recording admission decision: %s", err)
+			}
+		case <-cleanup.C:
+			deleted, err := r.cleanup(ctx, time.Now().Add(-r.retention))
+			if err != nil {
+				log.Errorf("while deleting expired admission decisions: %s", err)
+			} else if deleted > 0 {
+				log.Debugf("Deleted %d expired admission decisions", deleted)
+			}
+		}
+	}
+}
+
+// Returns an AdmissionDecision with the fields of the audit record as its spec.
+func decisionObject(namespace string, record audit.Record) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	spec := make(map[string]interface{})
+	err = json.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(admissionDecisionResource.GroupVersion().String())
+	obj.SetKind("AdmissionDecision")
+	obj.SetNamespace(namespace)
+	obj.SetGenerateName("decision-")
+	obj.SetLabels(map[string]string{
+		decisionManagedByLabel: decisionManagedBy,
+		decisionLabel:          record.Decision,
+	})
+	return obj, nil
+}
+
+func (r *DecisionRecorder) create(ctx context.Context, record audit.Record) error {
+	obj, err := decisionObject(r.namespace, record)
+	if err != nil {
+		return err
+	}
+	resources, err := resourceClient(r.client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, decisionTimeout)
+	defer cancel()
+	_, err = resources.Create(ctx, admissionDecisionResource, obj)
+	return err
+}
+
+// Returns the names of decisions created before the cutoff.
+func expiredDecisions(items []unstructured.Unstructured, cutoff time.Time) []string {
+	names := make([]string, 0)
+	for _, item := range items {
+		if item.GetCreationTimestamp().Time.Before(cutoff) {
+			names = append(names, item.GetName())
+		}
+	}
+	return names
+}
+
+// Delete decisions created before the cutoff. Returns the number of deleted decisions.
+func (r *DecisionRecorder) cleanup(ctx context.Context, cutoff time.Time) (int, error) {
+	resources, err := resourceClient(r.client)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, decisionTimeout)
+	defer cancel()
+
+	list, err := resources.List(ctx, admissionDecisionResource, r.namespace, metav1.ListOptions{LabelSelector: decisionManagedByLabel + "=" + decisionManagedBy})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, name := range expiredDecisions(list.Items, cutoff) {
+		err = resources.Delete(ctx, admissionDecisionResource, r.namespace, name)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,40 @@
+package kubeclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nais/tobac/pkg/audit"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDecisionObject(t *testing.T) {
+	obj, err := decisionObject("tobac", audit.Record{
+		User:     "user@example.com",
+		Decision: "denied",
+		Team:     "foo",
+		Reason:   "user 'user@example.com' has no access to team 'foo'",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "AdmissionDecision", obj.GetKind())
+	assert.Equal(t, "tobac", obj.GetNamespace())
+	assert.Equal(t, "denied", obj.GetLabels()[decisionLabel])
+
+	user, _, _ := unstructured.NestedString(obj.Object, "spec", "user")
+	assert.Equal(t, "user@example.com", user)
+	team, _, _ := unstructured.NestedString(obj.Object, "spec", "team")
+	assert.Equal(t, "foo", team)
+}
+
+func TestExpiredDecisions(t *testing.T) {
+	now := time.Now()
+	items := make([]unstructured.Unstructured, 2)
+	items[0].SetName("old")
+	items[0].SetCreationTimestamp(metav1.NewTime(now.Add(-2 * time.Hour)))
+	items[1].SetName("recent")
+	items[1].SetCreationTimestamp(metav1.NewTime(now.Add(-time.Minute)))
+
+	assert.Equal(t, []string{"old"}, expiredDecisions(items, now.Add(-time.Hour)))
+}
@@ -0,0 +1,68 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nais/tobac/pkg/requestlog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource returns the resource of a kind, found through the discovery endpoint of its group version. Resource lists
+// are cached, and fetched again when a kind is missing from them, e.g. after a custom resource has been defined.
+func (c *ResourceClient) Resource(ctx context.Context, kind schema.GroupVersionKind) (schema.GroupVersionResource, error) {
+	gv := kind.GroupVersion()
+	c.mutex.Lock()
+	resources, ok := c.discovered[gv]
+	c.mutex.Unlock()
+
+	if resource, found := kindResource(resources, kind); found {
+		return resource, nil
+	}
+	if ok {
+		requestlog.FromContext(ctx).Debugf("kind %s not found among cached resources of %s", kind.Kind, gv.String())
+	}
+
+	resources, err := c.discover(ctx, gv)
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("while discovering resources of %s: %s", gv.String(), err)
+	}
+	c.mutex.Lock()
+	c.discovered[gv] = resources
+	c.mutex.Unlock()
+
+	if resource, found := kindResource(resources, kind); found {
+		return resource, nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("no resource of kind %s in %s", kind.Kind, gv.String())
+}
+
+// Retrieve the resources served in a group version.
+func (c *ResourceClient) discover(ctx context.Context, gv schema.GroupVersion) ([]metav1.APIResource, error) {
+	path := []string{"apis", gv.Group, gv.Version}
+	if len(gv.Group) == 0 {
+		path = []string{"api", gv.Version}
+	}
+	var list metav1.APIResourceList
+	err := traced(ctx, "discover", gv.WithResource(""), func() error {
+		data, err := c.client.Get().Context(ctx).AbsPath(path...).DoRaw()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &list)
+	})
+	return list.APIResources, err
+}
+
+// Returns the resource of a kind among the resources of its group version, ignoring subresources such as pods/log.
+func kindResource(resources []metav1.APIResource, kind schema.GroupVersionKind) (schema.GroupVersionResource, bool) {
+	for _, resource := range resources {
+		if resource.Kind == kind.Kind && !strings.Contains(resource.Name, "/") {
+			return kind.GroupVersion().WithResource(resource.Name), true
+		}
+	}
+	return schema.GroupVersionResource{}, false
+}
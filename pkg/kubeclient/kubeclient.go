@@ -1,12 +1,19 @@
 package kubeclient
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/nais/tobac/pkg/requestlog"
+	"github.com/nais/tobac/pkg/tracing"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -14,22 +21,97 @@ import (
 )
 
 func New(config *rest.Config) (dynamic.Interface, error) {
-	return dynamic.NewForConfig(config)
+	return newClient(config)
 }
 
-func namespacedObject(client dynamic.Interface, req v1beta1.AdmissionRequest, identifier schema.GroupVersionResource) (metav1.Object, error) {
-	log.Debugf("using %+v to look up resource '%s' in namespace '%s'", identifier, req.Name, req.Namespace)
-	c := client.Resource(identifier)
-	return c.Namespace(req.Namespace).Get(req.Name, metav1.GetOptions{})
+// NewWatchClient returns a client without a request timeout, as watches last for minutes.
+func NewWatchClient(config *rest.Config) (dynamic.Interface, error) {
+	config = rest.CopyConfig(config)
+	config.Timeout = 0
+	return newClient(config)
 }
 
-func clusterObject(client dynamic.Interface, req v1beta1.AdmissionRequest, identifier schema.GroupVersionResource) (metav1.Object, error) {
-	log.Debugf("using %+v to look up resource '%s' in cluster scope", identifier, req.Name)
-	c := client.Resource(identifier)
-	return c.Get(req.Name, metav1.GetOptions{})
+// Run a request, tracing it with the verb and resource. Requests are made through the resource, typed and
+// metadata clients, which cancel them along with their context.
+func traced(ctx context.Context, verb string, resource schema.GroupVersionResource, request func() error) error {
+	_, span := tracing.Start(ctx, "kubernetes "+verb+" "+resource.Resource, tracing.Client)
+	span.SetAttribute("k8s.resource", resource.String())
+	defer span.End()
+
+	err := request()
+	span.SetError(err)
+	return err
+}
+
+// Avoid returning a nil object wrapped in a non-nil interface.
+func object(obj *unstructured.Unstructured, err error) (metav1.Object, error) {
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Look up an object in the metadata cache, if configured.
+func cachedObject(ctx context.Context, client dynamic.Interface, identifier schema.GroupVersionResource, namespace, name string) (metav1.Object, bool) {
+	if metadataCache == nil || additionalCluster(client) {
+		return nil, false
+	}
+	obj, ok := metadataCache.Get(identifier, namespace, name)
+	if ok {
+		requestlog.FromContext(ctx).Debugf("found %+v '%s' in namespace '%s' in metadata cache", identifier, name, namespace)
+	}
+	return obj, ok
+}
+
+// Retrieve an object from the API server, using the typed client for the built-in kinds it supports if enabled,
+// and the metadata client for other kinds if configured. The namespace is empty for cluster scoped resources.
+func get(ctx context.Context, client dynamic.Interface, identifier schema.GroupVersionResource, namespace, name string) (metav1.Object, error) {
+	typed, metadata := lookupClients(client)
+	if typed != nil {
+		if lookup, ok := typed.lookup(identifier); ok {
+			var obj metav1.Object
+			err := traced(ctx, "get", identifier, func() (err error) {
+				obj, err = lookup(ctx, namespace, name)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			return obj, nil
+		}
+	}
+	if metadata != nil {
+		var obj *unstructured.Unstructured
+		err := traced(ctx, "get", identifier, func() (err error) {
+			obj, err = metadata.Get(ctx, identifier, namespace, name)
+			return err
+		})
+		return object(obj, err)
+	}
+	resources, err := resourceClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return object(resources.Get(ctx, identifier, namespace, name))
+}
+
+func namespacedObject(ctx context.Context, client dynamic.Interface, req v1beta1.AdmissionRequest, identifier schema.GroupVersionResource) (metav1.Object, error) {
+	if obj, ok := cachedObject(ctx, client, identifier, req.Namespace, req.Name); ok {
+		return obj, nil
+	}
+	requestlog.FromContext(ctx).Debugf("using %+v to look up resource '%s' in namespace '%s'", identifier, req.Name, req.Namespace)
+	return get(ctx, client, identifier, req.Namespace, req.Name)
+}
+
+func clusterObject(ctx context.Context, client dynamic.Interface, req v1beta1.AdmissionRequest, identifier schema.GroupVersionResource) (metav1.Object, error) {
+	if obj, ok := cachedObject(ctx, client, identifier, "", req.Name); ok {
+		return obj, nil
+	}
+	requestlog.FromContext(ctx).Debugf("using %+v to look up resource '%s' in cluster scope", identifier, req.Name)
+	return get(ctx, client, identifier, "", req.Name)
 }
 
-func ObjectFromAdmissionRequest(client dynamic.Interface, req v1beta1.AdmissionRequest) (metav1.Object, error) {
+func ObjectFromAdmissionRequest(ctx context.Context, client dynamic.Interface, req v1beta1.AdmissionRequest) (metav1.Object, error) {
 	if len(req.Name) == 0 {
 		return nil, fmt.Errorf("resource name must be specified")
 	}
@@ -38,10 +120,202 @@ func ObjectFromAdmissionRequest(client dynamic.Interface, req v1beta1.AdmissionR
 		Version:  req.Resource.Version,
 		Resource: req.Resource.Resource,
 	}
-	if len(req.Namespace) == 0 {
-		return clusterObject(client, req, identifier)
+	cache := lookupCache
+	if additionalCluster(client) {
+		cache = nil
+	}
+	var generation uint64
+	if cache != nil {
+		if obj, ok := cache.get(requestKey(req), time.Now()); ok {
+			requestlog.FromContext(ctx).Debugf("found %+v '%s' in namespace '%s' in lookup cache", identifier, req.Name, req.Namespace)
+			return obj, nil
+		}
+		generation = cache.begin()
+	}
+	obj, err := retryLookup(ctx, lookupInitialBackoff, func() (metav1.Object, error) {
+		if len(req.Namespace) == 0 {
+			return clusterObject(ctx, client, req, identifier)
+		}
+		return namespacedObject(ctx, client, req, identifier)
+	})
+	if err == nil && cache != nil {
+		cache.put(requestKey(req), obj, time.Now(), generation)
+	}
+	return obj, err
+}
+
+// Namespace retrieves a namespace object from the namespace cache if configured, or from the Kubernetes API server.
+func Namespace(ctx context.Context, client dynamic.Interface, name string) (metav1.Object, error) {
+	if namespaceCache != nil && !additionalCluster(client) {
+		if obj, ok := namespaceCache.Get(name); ok {
+			requestlog.FromContext(ctx).Debugf("found namespace '%s' in namespace cache", name)
+			return obj, nil
+		}
+	}
+	if obj, ok := cachedObject(ctx, client, namespaceResource, "", name); ok {
+		return obj, nil
+	}
+	requestlog.FromContext(ctx).Debugf("looking up namespace '%s'", name)
+	return get(ctx, client, namespaceResource, "", name)
+}
+
+// Ping checks that the Kubernetes API server can be reached, by looking up the default namespace.
+// The metadata cache is bypassed.
+func Ping(ctx context.Context, client dynamic.Interface) error {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return err
+	}
+	_, err = resources.Get(ctx, namespaceResource, "", "default")
+	return err
+}
+
+var configMapResource = schema.GroupVersionResource{
+	Version:  "v1",
+	Resource: "configmaps",
+}
+
+// ConfigMapData retrieves the data of a ConfigMap from the Kubernetes API server.
+func ConfigMapData(ctx context.Context, client dynamic.Interface, namespace, name string) (map[string]string, error) {
+	requestlog.FromContext(ctx).Debugf("looking up configmap '%s' in namespace '%s'", name, namespace)
+	resources, err := resourceClient(client)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := resources.Get(ctx, configMapResource, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	return data, err
+}
+
+var secretResource = schema.GroupVersionResource{
+	Version:  "v1",
+	Resource: "secrets",
+}
+
+// TLSSecret retrieves the PEM encoded certificate and key of a kubernetes.io/tls Secret.
+func TLSSecret(ctx context.Context, client dynamic.Interface, namespace, name string) (certPEM, keyPEM []byte, err error) {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := resources.Get(ctx, secretResource, namespace, name)
+	if err != nil {
+		return nil, nil, err
 	}
-	return namespacedObject(client, req, identifier)
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decoded := make([][]byte, 0, 2)
+	for _, key := range []string{"tls.crt", "tls.key"} {
+		value, err := base64.StdEncoding.DecodeString(data[key])
+		if err != nil {
+			return nil, nil, fmt.Errorf("while decoding '%s' in secret '%s/%s': %s", key, namespace, name, err)
+		}
+		if len(value) == 0 {
+			return nil, nil, fmt.Errorf("secret '%s/%s' has no '%s'", namespace, name, key)
+		}
+		decoded = append(decoded, value)
+	}
+
+	return decoded[0], decoded[1], nil
+}
+
+// WriteConfigMap replaces the data of a ConfigMap, creating it if it does not exist.
+func WriteConfigMap(ctx context.Context, client dynamic.Interface, namespace, name string, data map[string]string) error {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return err
+	}
+
+	obj, err := resources.Get(ctx, configMapResource, namespace, name)
+	if errors.IsNotFound(err) {
+		obj = &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		err = unstructured.SetNestedStringMap(obj.Object, data, "data")
+		if err != nil {
+			return err
+		}
+		_, err = resources.Create(ctx, configMapResource, obj)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	err = unstructured.SetNestedStringMap(obj.Object, data, "data")
+	if err != nil {
+		return err
+	}
+	_, err = resources.Update(ctx, configMapResource, obj)
+	return err
+}
+
+// Webhook configurations are served as v1 by current API servers, and only as v1beta1 by older ones.
+var webhookConfigurationResources = []schema.GroupVersionResource{
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"},
+	{Group: "admissionregistration.k8s.io", Version: "v1beta1", Resource: "validatingwebhookconfigurations"},
+}
+
+// PatchCABundle sets the CA bundle of every webhook in a ValidatingWebhookConfiguration.
+// Returns false if the CA bundle was already up to date.
+func PatchCABundle(ctx context.Context, client dynamic.Interface, name string, caBundle []byte) (bool, error) {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range webhookConfigurationResources {
+		obj, err := resources.Get(ctx, resource, "", name)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		changed, err := setCABundle(obj, base64.StdEncoding.EncodeToString(caBundle))
+		if err != nil || !changed {
+			return false, err
+		}
+
+		_, err = resources.Update(ctx, resource, obj)
+		return err == nil, err
+	}
+	return false, fmt.Errorf("validating webhook configuration '%s' not found", name)
+}
+
+func setCABundle(obj *unstructured.Unstructured, caBundle string) (bool, error) {
+	webhooks, _, err := unstructured.NestedSlice(obj.Object, "webhooks")
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for i := range webhooks {
+		webhook, ok := webhooks[i].(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("malformed webhook %d", i)
+		}
+		current, _, _ := unstructured.NestedString(webhook, "clientConfig", "caBundle")
+		if current == caBundle {
+			continue
+		}
+		err = unstructured.SetNestedField(webhook, caBundle, "clientConfig", "caBundle")
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, unstructured.SetNestedSlice(obj.Object, webhooks, "webhooks")
 }
 
 func kubeconfig() (string, error) {
@@ -52,14 +326,36 @@ func kubeconfig() (string, error) {
 	return env, nil
 }
 
-func Config() (*rest.Config, error) {
+// Config returns the configuration from $KUBECONFIG, or the in-cluster configuration if unset.
+// If context is set, that context is used instead of the current context of the kubeconfig file.
+// If server is set, the API server is reached at that URL instead of the configured one.
+func Config(context, server string) (*rest.Config, error) {
 	path, err := kubeconfig()
 	if err != nil {
+		if len(context) > 0 {
+			return nil, fmt.Errorf("kubeconfig context '%s' specified, but %s", context, err)
+		}
 		log.Info(err.Error())
 		log.Info("assuming running inside Kubernetes, using in-cluster configuration")
-		return rest.InClusterConfig()
-	} else {
-		log.Infof("using configuration from '%s'", path)
-		return clientcmd.BuildConfigFromFlags("", path)
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+		if len(server) > 0 {
+			config.Host = server
+		}
+		return config, nil
+	}
+
+	log.Infof("using configuration from '%s'", path)
+	return ConfigFromFile(path, context, server)
+}
+
+// ConfigFromFile returns the configuration from a kubeconfig file, using the specified context and server if set.
+func ConfigFromFile(path, context, server string) (*rest.Config, error) {
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: context,
 	}
+	overrides.ClusterInfo.Server = server
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(&clientcmd.ClientConfigLoadingRules{ExplicitPath: path}, overrides).ClientConfig()
 }
@@ -0,0 +1,49 @@
+package kubeclient
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: primary
+clusters:
+- name: primary
+  cluster:
+    server: https://primary.example.com
+- name: secondary
+  cluster:
+    server: https://secondary.example.com
+contexts:
+- name: primary
+  context:
+    cluster: primary
+- name: secondary
+  context:
+    cluster: secondary
+`
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testKubeconfig), 0600))
+	t.Setenv("KUBECONFIG", path)
+
+	config, err := Config("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://primary.example.com", config.Host)
+
+	config, err = Config("secondary", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://secondary.example.com", config.Host)
+
+	config, err = Config("secondary", "https://override.example.com:6443")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://override.example.com:6443", config.Host)
+
+	_, err = Config("missing", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package kubeclient
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var leaseResource = schema.GroupVersionResource{
+	Group:    "coordination.k8s.io",
+	Version:  "v1",
+	Resource: "leases",
+}
+
+// Format of MicroTime fields such as renewTime.
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// AcquireLease claims or renews a Lease for the specified identity. Returns false if the lease is held
+// by someone else, or if another candidate updated it concurrently.
+func AcquireLease(ctx context.Context, client dynamic.Interface, namespace, name, identity string, duration time.Duration) (bool, error) {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+
+	obj, err := resources.Get(ctx, leaseResource, namespace, name)
+	if errors.IsNotFound(err) {
+		obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetAPIVersion(leaseResource.GroupVersion().String())
+		obj.SetKind("Lease")
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		claimLease(obj, identity, duration, now)
+		_, err = resources.Create(ctx, leaseResource, obj)
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	if !claimLease(obj, identity, duration, now) {
+		return false, nil
+	}
+	_, err = resources.Update(ctx, leaseResource, obj)
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Update the lease to be held by the identity, unless it is held by someone else and has not expired.
+// Returns false if the lease cannot be claimed.
+func claimLease(obj *unstructured.Unstructured, identity string, duration time.Duration, now time.Time) bool {
+	holder, _, _ := unstructured.NestedString(obj.Object, "spec", "holderIdentity")
+	if len(holder) > 0 && holder != identity {
+		renewed, _, _ := unstructured.NestedString(obj.Object, "spec", "renewTime")
+		seconds, _, _ := unstructured.NestedInt64(obj.Object, "spec", "leaseDurationSeconds")
+		renewTime, err := time.Parse(microTimeFormat, renewed)
+		if err == nil && now.Before(renewTime.Add(time.Duration(seconds)*time.Second)) {
+			return false
+		}
+	}
+
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	if spec == nil {
+		spec = make(map[string]interface{})
+	}
+	timestamp := now.UTC().Format(microTimeFormat)
+	if holder != identity {
+		transitions, _, _ := unstructured.NestedInt64(obj.Object, "spec", "leaseTransitions")
+		if len(holder) > 0 {
+			transitions++
+		}
+		spec["holderIdentity"] = identity
+		spec["acquireTime"] = timestamp
+		spec["leaseTransitions"] = transitions
+	}
+	spec["leaseDurationSeconds"] = int64(duration / time.Second)
+	spec["renewTime"] = timestamp
+	obj.Object["spec"] = spec
+	return true
+}
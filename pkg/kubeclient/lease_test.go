@@ -0,0 +1,28 @@
+package kubeclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestClaimLease(t *testing.T) {
+	now := time.Now()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	assert.True(t, claimLease(obj, "first", 15*time.Second, now))
+	holder, _, _ := unstructured.NestedString(obj.Object, "spec", "holderIdentity")
+	assert.Equal(t, "first", holder)
+
+	// The holder may renew the lease, while others must wait for it to expire.
+	assert.True(t, claimLease(obj, "first", 15*time.Second, now.Add(10*time.Second)))
+	assert.False(t, claimLease(obj, "second", 15*time.Second, now.Add(20*time.Second)))
+	assert.True(t, claimLease(obj, "second", 15*time.Second, now.Add(30*time.Second)))
+
+	holder, _, _ = unstructured.NestedString(obj.Object, "spec", "holderIdentity")
+	transitions, _, _ := unstructured.NestedInt64(obj.Object, "spec", "leaseTransitions")
+	assert.Equal(t, "second", holder)
+	assert.Equal(t, int64(1), transitions)
+}
@@ -0,0 +1,113 @@
+package kubeclient
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+type lookupKey struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+type lookupEntry struct {
+	obj     metav1.Object
+	expires time.Time
+}
+
+// LookupCache keeps existing objects retrieved from the API server for a short while, so that bursts of requests
+// for the same object, e.g. from a controller retrying a deletion, do not cause identical lookups. Objects are only
+// invalidated by requests seen by the same replica, so the TTL is how long other replicas may decide requests by
+// an outdated object, such as one whose team label has changed.
+type LookupCache struct {
+	ttl   time.Duration
+	mutex sync.Mutex
+	cache map[lookupKey]lookupEntry
+	// Number of invalidations, so that objects looked up while an invalidation happened are not cached.
+	generation uint64
+}
+
+// MaxLookupCacheTTL limits how long replicas may decide requests by outdated objects.
+const MaxLookupCacheTTL = time.Minute
+
+func NewLookupCache(ttl time.Duration) *LookupCache {
+	return &LookupCache{
+		ttl:   ttl,
+		cache: make(map[lookupKey]lookupEntry),
+	}
+}
+
+var lookupCache *LookupCache
+
+// SetLookupCache configures a cache for the results of existing object lookups.
+func SetLookupCache(cache *LookupCache) {
+	lookupCache = cache
+}
+
+// Returns the key of the object an admission request is about. Subresources share the key of their object.
+func requestKey(req v1beta1.AdmissionRequest) lookupKey {
+	return lookupKey{
+		resource: schema.GroupVersionResource{
+			Group:    req.Resource.Group,
+			Version:  req.Resource.Version,
+			Resource: req.Resource.Resource,
+		},
+		namespace: req.Namespace,
+		name:      req.Name,
+	}
+}
+
+func (c *LookupCache) get(key lookupKey, now time.Time) (metav1.Object, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.cache[key]
+	if !found || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.obj, true
+}
+
+// Returns the generation to pass to put for a lookup starting now.
+func (c *LookupCache) begin() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// Caches an object looked up since begin returned generation. The object is not cached if anything was
+// invalidated meanwhile, as it may have been retrieved before the change the invalidation is about.
+func (c *LookupCache) put(key lookupKey, obj metav1.Object, now time.Time, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.cache[key] = lookupEntry{obj: obj, expires: now.Add(c.ttl)}
+	for k, e := range c.cache {
+		if !now.Before(e.expires) {
+			delete(c.cache, k)
+		}
+	}
+}
+
+// Invalidate forgets the object an admission request is about, e.g. because the request creates, changes or deletes it.
+func (c *LookupCache) Invalidate(req v1beta1.AdmissionRequest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.cache, requestKey(req))
+	c.generation++
+}
+
+// InvalidateLookup forgets the object an admission request is about, if the lookup cache is configured.
+// Objects of additional clusters are not cached.
+func InvalidateLookup(client dynamic.Interface, req v1beta1.AdmissionRequest) {
+	if lookupCache != nil && !additionalCluster(client) {
+		lookupCache.Invalidate(req)
+	}
+}
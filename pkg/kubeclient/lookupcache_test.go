@@ -0,0 +1,44 @@
+package kubeclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLookupCache(t *testing.T) {
+	cache := NewLookupCache(time.Second)
+	now := time.Now()
+	req := v1beta1.AdmissionRequest{
+		Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		Namespace: "default",
+		Name:      "foo",
+	}
+	key := requestKey(req)
+
+	_, ok := cache.get(key, now)
+	assert.False(t, ok)
+
+	cache.put(key, &metav1.ObjectMeta{Name: "foo"}, now, cache.begin())
+	obj, ok := cache.get(key, now.Add(time.Second/2))
+	assert.True(t, ok)
+	assert.Equal(t, "foo", obj.GetName())
+
+	_, ok = cache.get(key, now.Add(time.Second))
+	assert.False(t, ok, "entries expire after the TTL")
+
+	cache.put(key, &metav1.ObjectMeta{Name: "foo"}, now, cache.begin())
+	req.SubResource = "scale"
+	cache.Invalidate(req)
+	_, ok = cache.get(key, now)
+	assert.False(t, ok, "changes to subresources invalidate their object")
+
+	generation := cache.begin()
+	cache.Invalidate(req)
+	cache.put(key, &metav1.ObjectMeta{Name: "foo"}, now, generation)
+	_, ok = cache.get(key, now)
+	assert.False(t, ok, "objects looked up while an invalidation happened are not cached")
+}
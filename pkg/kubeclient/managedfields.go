@@ -0,0 +1,62 @@
+package kubeclient
+
+import (
+	"encoding/json"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// An entry of the managed fields of an object, recording which field manager last applied or updated
+// some of its fields. The object metadata types of this client-go version predate managed fields.
+type managedFieldsEntry struct {
+	Manager   string    `json:"manager"`
+	Operation string    `json:"operation"`
+	Time      time.Time `json:"time"`
+}
+
+type managedFieldsObject struct {
+	Metadata struct {
+		ManagedFields []managedFieldsEntry `json:"managedFields"`
+	} `json:"metadata"`
+}
+
+// Returns the field manager of the most recent entry, or an empty string if there are no entries.
+func lastManager(entries []managedFieldsEntry) string {
+	var last *managedFieldsEntry
+	for i := range entries {
+		if last == nil || entries[i].Time.After(last.Time) {
+			last = &entries[i]
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return last.Manager
+}
+
+// LastManagerOfJSON returns the field manager that most recently applied or updated the JSON encoded object.
+// Returns an empty string if the object has no managed fields.
+func LastManagerOfJSON(raw []byte) string {
+	obj := managedFieldsObject{}
+	if len(raw) == 0 || json.Unmarshal(raw, &obj) != nil {
+		return ""
+	}
+	return lastManager(obj.Metadata.ManagedFields)
+}
+
+// LastManager returns the field manager that most recently applied or updated an object retrieved by
+// this package. Managed fields are only known for objects retrieved as unstructured or partial object metadata,
+// so an empty string is returned for built-in kinds retrieved by the typed client.
+func LastManager(obj metav1.Object) string {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	raw, err := u.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	return LastManagerOfJSON(raw)
+}
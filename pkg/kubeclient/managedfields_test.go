@@ -0,0 +1,26 @@
+package kubeclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const managedObject = `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"foo","managedFields":[
+	{"manager":"kubectl","operation":"Apply","time":"2020-01-01T10:00:00Z"},
+	{"manager":"naiserator","operation":"Update","time":"2020-01-02T10:00:00Z"},
+	{"manager":"kube-controller-manager","operation":"Update","time":"2020-01-01T12:00:00Z"}
+]}}`
+
+func TestLastManager(t *testing.T) {
+	assert.Equal(t, "naiserator", LastManagerOfJSON([]byte(managedObject)))
+	assert.Equal(t, "", LastManagerOfJSON([]byte(`{"metadata":{"name":"foo"}}`)))
+	assert.Equal(t, "", LastManagerOfJSON(nil))
+
+	obj := &unstructured.Unstructured{}
+	assert.NoError(t, obj.UnmarshalJSON([]byte(managedObject)))
+	assert.Equal(t, "naiserator", LastManager(obj))
+	assert.Equal(t, "", LastManager(&metav1.ObjectMeta{Name: "foo"}))
+}
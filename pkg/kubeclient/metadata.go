@@ -0,0 +1,89 @@
+package kubeclient
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+)
+
+// Asks the API server to return only the metadata of an object. Servers not supporting this return the full object.
+const partialObjectMetadataAccept = "application/json;as=PartialObjectMetadata;g=meta.k8s.io;v=v1beta1, application/json"
+
+// MetadataClient retrieves the metadata of objects without transferring and decoding their full contents.
+type MetadataClient struct {
+	client rest.Interface
+}
+
+// NewMetadataClient returns a client using the specified configuration.
+func NewMetadataClient(config *rest.Config) (*MetadataClient, error) {
+	client, err := restClient(config, partialObjectMetadataAccept)
+	if err != nil {
+		return nil, err
+	}
+	return &MetadataClient{client: client}, nil
+}
+
+// Returns a client for requests by absolute path, accepting the specified content types.
+// Error responses are decoded into status errors.
+func restClient(config *rest.Config, accept string) (rest.Interface, error) {
+	scheme := runtime.NewScheme()
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
+
+	config = rest.CopyConfig(config)
+	config.GroupVersion = &schema.GroupVersion{}
+	config.APIPath = "/"
+	config.AcceptContentTypes = accept
+	config.ContentType = "application/json"
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	if len(config.UserAgent) == 0 {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	return rest.RESTClientFor(config)
+}
+
+var metadataClient *MetadataClient
+
+// SetMetadataClient configures a client used instead of the dynamic client for looking up existing objects.
+func SetMetadataClient(client *MetadataClient) {
+	metadataClient = client
+}
+
+// Get returns an object with only its metadata set. A namespace is required for namespaced resources.
+// The request is cancelled when the context is done.
+func (c *MetadataClient) Get(ctx context.Context, resource schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	data, err := c.client.Get().Context(ctx).AbsPath(resourcePath(resource, namespace, name)...).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	obj, err := runtime.Decode(unstructured.UnstructuredJSONScheme, data)
+	if err != nil {
+		return nil, err
+	}
+	full := obj.(*unstructured.Unstructured)
+
+	// Drop everything but metadata from servers returning the full object.
+	partial := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "meta.k8s.io/v1beta1",
+		"kind":       "PartialObjectMetadata",
+		"metadata":   full.Object["metadata"],
+	}}
+	return partial, nil
+}
+
+// Returns the URL path segments of an object, or of the resource collection if the name is empty.
+func resourcePath(resource schema.GroupVersionResource, namespace, name string) []string {
+	path := []string{"api"}
+	if len(resource.Group) > 0 {
+		path = []string{"apis", resource.Group}
+	}
+	path = append(path, resource.Version)
+	if len(namespace) > 0 {
+		path = append(path, "namespaces", namespace)
+	}
+	return append(path, resource.Resource, name)
+}
@@ -0,0 +1,73 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+func TestMetadataClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, partialObjectMetadataAccept, r.Header.Get("Accept"))
+		switch r.URL.Path {
+		case "/apis/nais.io/v1alpha1/namespaces/default/applications/foo":
+			// A server not supporting partial object metadata returns the full object.
+			fmt.Fprint(w, `{"apiVersion":"nais.io/v1alpha1","kind":"Application","metadata":{"name":"foo","labels":{"team":"bar"}},"spec":{"image":"foo"}}`)
+		case "/api/v1/namespaces/default":
+			fmt.Fprint(w, `{"apiVersion":"meta.k8s.io/v1beta1","kind":"PartialObjectMetadata","metadata":{"name":"default"}}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewMetadataClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	applications := schema.GroupVersionResource{Group: "nais.io", Version: "v1alpha1", Resource: "applications"}
+	obj, err := client.Get(context.Background(), applications, "default", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", obj.GetLabels()["team"])
+	assert.NotContains(t, obj.Object, "spec")
+
+	namespaces := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	obj, err = client.Get(context.Background(), namespaces, "", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "default", obj.GetName())
+
+	_, err = client.Get(context.Background(), namespaces, "", "missing")
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestMetadataClientCancellation(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	client, err := NewMetadataClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(ctx, schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "", "default")
+	assert.Error(t, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request was not cancelled at the context deadline")
+	}
+}
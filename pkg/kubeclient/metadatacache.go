@@ -0,0 +1,214 @@
+package kubeclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// Delay before listing a resource again after its watch failed.
+const watchRetryInterval = 5 * time.Second
+
+// Watches are restarted after this many seconds, to spread the load of relisting on the API server.
+const watchTimeoutSeconds = 300
+
+// Number of objects retrieved per request when listing a resource.
+const listPageSize = 500
+
+// MetadataCache keeps the metadata of every object of the cached resources looked up through it, so that existing
+// objects can be found without querying the API server. A resource is listed and watched in all namespaces the
+// first time it is looked up, which requires permission to list and watch it cluster-wide. Lookups are not
+// answered from the cache until the resource has been listed.
+type MetadataCache struct {
+	ctx       context.Context
+	client    dynamic.Interface
+	cached    map[string]bool
+	mutex     sync.Mutex
+	resources map[schema.GroupVersionResource]*metadataStore
+}
+
+// NewMetadataCache returns a cache that watches resources until the context is done. Only resources named in
+// the list, e.g. 'pods', are cached. Lookups of other resources are not answered from the cache.
+func NewMetadataCache(ctx context.Context, client dynamic.Interface, resources []string) *MetadataCache {
+	cached := make(map[string]bool, len(resources))
+	for _, resource := range resources {
+		cached[resource] = true
+	}
+	return &MetadataCache{
+		ctx:       ctx,
+		client:    client,
+		cached:    cached,
+		resources: make(map[schema.GroupVersionResource]*metadataStore),
+	}
+}
+
+var metadataCache *MetadataCache
+
+// SetMetadataCache configures a cache consulted before looking up existing objects in the API server.
+func SetMetadataCache(cache *MetadataCache) {
+	metadataCache = cache
+}
+
+// Get returns the metadata of an object. Returns false if the resource is not cached or has not been listed yet,
+// or if the object is not in the cache, which may be because it was created very recently.
+func (c *MetadataCache) Get(resource schema.GroupVersionResource, namespace, name string) (metav1.Object, bool) {
+	if !c.cached[resource.Resource] {
+		return nil, false
+	}
+	c.mutex.Lock()
+	store, ok := c.resources[resource]
+	if !ok {
+		store = newMetadataStore()
+		c.resources[resource] = store
+		go c.watch(resource, store)
+	}
+	c.mutex.Unlock()
+	return store.get(namespace, name)
+}
+
+// List and watch a resource until the context is done, or until the resource turns out not to be listable.
+func (c *MetadataCache) watch(resource schema.GroupVersionResource, store *metadataStore) {
+	for {
+		err := c.sync(resource, store)
+		if errors.IsForbidden(err) || errors.IsNotFound(err) || errors.IsMethodNotSupported(err) {
+			log.Warnf("Not caching %s: %s", resource.String(), err)
+			return
+		}
+		if err != nil {
+			log.Debugf("while watching %s: %s", resource.String(), err)
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// List the resource, and apply changes to the store until the watch ends. The list is cancelled along
+// with the context of the cache, while the watch is stopped when the context is done.
+func (c *MetadataCache) sync(resource schema.GroupVersionResource, store *metadataStore) error {
+	resources, err := resourceClient(c.client)
+	if err != nil {
+		return err
+	}
+	items, resourceVersion, err := listAll(c.ctx, resources, resource)
+	if err != nil {
+		return err
+	}
+	store.replace(items)
+
+	timeout := int64(watchTimeoutSeconds)
+	watcher, err := c.client.Resource(resource).Watch(metav1.ListOptions{ResourceVersion: resourceVersion, TimeoutSeconds: &timeout})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				return errors.FromObject(event.Object)
+			}
+			store.apply(event)
+		}
+	}
+}
+
+// List the objects of a resource in all namespaces, a page at a time. Returns the objects and the resource
+// version to watch from. If the list has changed too much between pages, listing fails and is retried later.
+func listAll(ctx context.Context, resources *ResourceClient, resource schema.GroupVersionResource) ([]unstructured.Unstructured, string, error) {
+	items := make([]unstructured.Unstructured, 0)
+	options := metav1.ListOptions{Limit: listPageSize}
+	for {
+		list, err := resources.List(ctx, resource, "", options)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, list.Items...)
+		options.Continue = list.GetContinue()
+		if len(options.Continue) == 0 {
+			return items, list.GetResourceVersion(), nil
+		}
+	}
+}
+
+// Object metadata by namespace and name.
+type metadataStore struct {
+	mutex   sync.RWMutex
+	synced  bool
+	objects map[string]metav1.Object
+}
+
+func newMetadataStore() *metadataStore {
+	return &metadataStore{
+		objects: make(map[string]metav1.Object),
+	}
+}
+
+func storeKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Keep only the type and metadata of an object.
+func metadataOnly(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	stripped := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": obj.GetAPIVersion(),
+		"kind":       obj.GetKind(),
+	}}
+	if metadata, ok := obj.Object["metadata"]; ok {
+		stripped.Object["metadata"] = metadata
+	}
+	return stripped
+}
+
+func (s *metadataStore) get(namespace, name string) (metav1.Object, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if !s.synced {
+		return nil, false
+	}
+	obj, ok := s.objects[storeKey(namespace, name)]
+	return obj, ok
+}
+
+func (s *metadataStore) replace(items []unstructured.Unstructured) {
+	objects := make(map[string]metav1.Object, len(items))
+	for i := range items {
+		objects[storeKey(items[i].GetNamespace(), items[i].GetName())] = metadataOnly(&items[i])
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects = objects
+	s.synced = true
+}
+
+func (s *metadataStore) apply(event watch.Event) {
+	obj, ok := event.Object.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := storeKey(obj.GetNamespace(), obj.GetName())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		s.objects[key] = metadataOnly(obj)
+	case watch.Deleted:
+		delete(s.objects, key)
+	}
+}
@@ -0,0 +1,81 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+func pod(namespace, name, team string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"containers": []interface{}{}},
+	}}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Pod")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{"team": team})
+	return obj
+}
+
+func TestMetadataStore(t *testing.T) {
+	store := newMetadataStore()
+	_, ok := store.get("default", "first")
+	assert.False(t, ok, "lookups are not answered before the resource is listed")
+
+	store.replace([]unstructured.Unstructured{*pod("default", "first", "foo")})
+	obj, ok := store.get("default", "first")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", obj.GetLabels()["team"])
+	assert.NotContains(t, obj.(*unstructured.Unstructured).Object, "spec", "only metadata is kept")
+
+	store.apply(watch.Event{Type: watch.Modified, Object: pod("default", "first", "bar")})
+	store.apply(watch.Event{Type: watch.Added, Object: pod("other", "first", "baz")})
+	obj, _ = store.get("default", "first")
+	assert.Equal(t, "bar", obj.GetLabels()["team"])
+	obj, _ = store.get("other", "first")
+	assert.Equal(t, "baz", obj.GetLabels()["team"])
+
+	store.apply(watch.Event{Type: watch.Deleted, Object: pod("default", "first", "bar")})
+	_, ok = store.get("default", "first")
+	assert.False(t, ok)
+}
+
+func TestListAll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/pods", r.URL.Path)
+		assert.Equal(t, fmt.Sprint(listPageSize), r.URL.Query().Get("limit"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("continue") {
+		case "":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","metadata":{"resourceVersion":"1","continue":"next"},"items":[`+
+				`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"first","namespace":"default"}}]}`)
+		case "next":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","metadata":{"resourceVersion":"1"},"items":[`+
+				`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"second","namespace":"other"}}]}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewResourceClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	items, resourceVersion, err := listAll(context.Background(), client, schema.GroupVersionResource{Version: "v1", Resource: "pods"})
+	assert.NoError(t, err)
+	assert.Len(t, items, 2, "objects are listed from every page")
+	assert.Equal(t, "1", resourceVersion)
+}
+
+func TestMetadataCacheResources(t *testing.T) {
+	cache := NewMetadataCache(context.Background(), nil, []string{"pods"})
+	_, ok := cache.Get(schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, "default", "foo")
+	assert.False(t, ok)
+	assert.Empty(t, cache.resources, "resources that are not cached are not watched")
+}
@@ -0,0 +1,40 @@
+package kubeclient
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var namespaceResource = schema.GroupVersionResource{
+	Version:  "v1",
+	Resource: "namespaces",
+}
+
+// NamespaceCache keeps the metadata of every namespace, so that namespace labels can be read without
+// querying the API server. Namespaces are watched from the start, and changes replace cached entries.
+type NamespaceCache struct {
+	cache *MetadataCache
+}
+
+// NewNamespaceCache returns a cache that watches namespaces until the context is done.
+func NewNamespaceCache(ctx context.Context, client dynamic.Interface) *NamespaceCache {
+	c := &NamespaceCache{cache: NewMetadataCache(ctx, client, []string{namespaceResource.Resource})}
+	c.Get("")
+	return c
+}
+
+var namespaceCache *NamespaceCache
+
+// SetNamespaceCache configures a cache consulted before looking up namespaces in the API server.
+func SetNamespaceCache(cache *NamespaceCache) {
+	namespaceCache = cache
+}
+
+// Get returns the metadata of a namespace. Returns false until namespaces have been listed,
+// or if the namespace is not in the cache, which may be because it was created very recently.
+func (c *NamespaceCache) Get(name string) (metav1.Object, bool) {
+	return c.cache.Get(namespaceResource, "", name)
+}
@@ -0,0 +1,49 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+func TestNamespaceCache(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"NamespaceList","metadata":{"resourceVersion":"1"},"items":[`+
+				`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo","labels":{"team":"foo"}}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"type":"MODIFIED","object":{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo","labels":{"team":"bar"}}}}`+"\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := NewWatchClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewNamespaceCache(ctx, client)
+
+	assert.Eventually(t, func() bool {
+		namespace, ok := cache.Get("foo")
+		return ok && namespace.GetLabels()["team"] == "bar"
+	}, time.Second, time.Millisecond, "namespace changes replace cached labels")
+
+	_, ok := cache.Get("missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,115 @@
+package kubeclient
+
+import (
+	"context"
+
+	"github.com/nais/tobac/pkg/requestlog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Maximum number of owners followed, guarding against reference cycles.
+const maxOwnerDepth = 5
+
+// Returns the resource of the object referenced by an owner reference.
+func ownerResource(ctx context.Context, client dynamic.Interface, ref metav1.OwnerReference) (schema.GroupVersionResource, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	resources, err := resourceClient(client)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return resources.Resource(ctx, gv.WithKind(ref.Kind))
+}
+
+// LabeledOwner follows the controller references of an object, e.g. from a Pod through its ReplicaSet to its
+// Deployment, until an owner with the label is found. Returns nil if no owner has the label.
+func LabeledOwner(ctx context.Context, client dynamic.Interface, obj metav1.Object, label string) (metav1.Object, error) {
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			return nil, nil
+		}
+		resource, err := ownerResource(ctx, client, *ref)
+		if err != nil {
+			return nil, err
+		}
+
+		owner, err := lookupOwner(ctx, client, resource, *ref, obj.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+
+		// The owner has been replaced by another object with the same name.
+		if owner.GetUID() != ref.UID {
+			return nil, nil
+		}
+		if _, ok := owner.GetLabels()[label]; ok {
+			return owner, nil
+		}
+		obj = owner
+	}
+	return nil, nil
+}
+
+// Retrieve the object referenced by an owner reference, from the metadata cache if possible.
+func lookupOwner(ctx context.Context, client dynamic.Interface, resource schema.GroupVersionResource, ref metav1.OwnerReference, namespace string) (metav1.Object, error) {
+	if owner, ok := cachedObject(ctx, client, resource, namespace, ref.Name); ok {
+		return owner, nil
+	}
+	requestlog.FromContext(ctx).Debugf("looking up owner %s '%s' in namespace '%s'", ref.Kind, ref.Name, namespace)
+	return get(ctx, client, resource, namespace, ref.Name)
+}
+
+// Applications are custom resources deployed by naiserator, which carry the team of the workloads they own.
+var applicationResource = schema.GroupVersionResource{Group: "nais.io", Version: "v1alpha1", Resource: "applications"}
+
+// Returns the reference to a nais Application among owner references, if any.
+func applicationReference(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		gv, err := schema.ParseGroupVersion(refs[i].APIVersion)
+		if err == nil && gv.Group == applicationResource.Group && refs[i].Kind == "Application" {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// Application finds the nais Application an object descends from. Owner references are followed through
+// controllers, e.g. from a Pod through its ReplicaSet to its Deployment, until one of them references an
+// Application. Labels are not trusted, as anyone creating a pod can set them. Returns nil if the object does
+// not belong to an Application.
+func Application(ctx context.Context, client dynamic.Interface, obj metav1.Object) (metav1.Object, error) {
+	namespace := obj.GetNamespace()
+	current := obj
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		if ref := applicationReference(current.GetOwnerReferences()); ref != nil {
+			application, err := lookupOwner(ctx, client, applicationResource, *ref, namespace)
+			if errors.IsNotFound(err) || (err == nil && application.GetUID() != ref.UID) {
+				return nil, nil
+			}
+			return application, err
+		}
+
+		ref := metav1.GetControllerOf(current)
+		if ref == nil {
+			break
+		}
+		resource, err := ownerResource(ctx, client, *ref)
+		if err != nil {
+			return nil, err
+		}
+		owner, err := lookupOwner(ctx, client, resource, *ref, namespace)
+		if errors.IsNotFound(err) || (err == nil && owner.GetUID() != ref.UID) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		current = owner
+	}
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Objects served by the fake API server, by path.
+var owners = map[string]string{
+	"/apis/apps/v1/namespaces/default/replicasets/foo-1234": `{"apiVersion":"apps/v1","kind":"ReplicaSet","metadata":{"name":"foo-1234","namespace":"default","uid":"rs",` +
+		`"ownerReferences":[{"apiVersion":"apps/v1","kind":"Deployment","name":"foo","uid":"deployment","controller":true}]}}`,
+	"/apis/apps/v1/namespaces/default/deployments/foo": `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"foo","namespace":"default","uid":"deployment","labels":{"team":"foo"},` +
+		`"ownerReferences":[{"apiVersion":"nais.io/v1alpha1","kind":"Application","name":"foo","uid":"application","controller":true}]}}`,
+	"/apis/nais.io/v1alpha1/namespaces/default/applications/foo": `{"apiVersion":"nais.io/v1alpha1","kind":"Application","metadata":{"name":"foo","namespace":"default","uid":"application","labels":{"team":"foo"}}}`,
+
+	"/api/v1": `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"pods/log","kind":"Pod"},{"name":"pods","kind":"Pod"}]}`,
+	"/apis/apps/v1": `{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[` +
+		`{"name":"deployments","kind":"Deployment"},{"name":"replicasets","kind":"ReplicaSet"}]}`,
+	"/apis/nais.io/v1alpha1": `{"kind":"APIResourceList","groupVersion":"nais.io/v1alpha1","resources":[{"name":"applications","kind":"Application"}]}`,
+	"/apis/example.com/v1":   `{"kind":"APIResourceList","groupVersion":"example.com/v1","resources":[{"name":"policies","kind":"Policy"}]}`,
+}
+
+func ownerServer(t *testing.T) dynamic.Interface {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		obj, ok := owners[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`)
+			return
+		}
+		fmt.Fprint(w, obj)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := New(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	return client
+}
+
+// Returns a pod in the default namespace controlled by the specified owner.
+func ownedPod(kind, name string, uid types.UID, labels map[string]string) metav1.Object {
+	controller := true
+	pod := &metav1.ObjectMeta{Name: "foo-1234-abcd", Namespace: "default", Labels: labels}
+	if len(kind) > 0 {
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid, Controller: &controller}}
+	}
+	return pod
+}
+
+func TestOwnerResource(t *testing.T) {
+	client := ownerServer(t)
+	ctx := context.Background()
+
+	for ref, expected := range map[metav1.OwnerReference]schema.GroupVersionResource{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet"}:           {Group: "apps", Version: "v1", Resource: "replicasets"},
+		{APIVersion: "v1", Kind: "Pod"}:                       {Version: "v1", Resource: "pods"},
+		{APIVersion: "nais.io/v1alpha1", Kind: "Application"}: {Group: "nais.io", Version: "v1alpha1", Resource: "applications"},
+		{APIVersion: "example.com/v1", Kind: "Policy"}:        {Group: "example.com", Version: "v1", Resource: "policies"},
+	} {
+		resource, err := ownerResource(ctx, client, ref)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, resource)
+	}
+
+	for _, ref := range []metav1.OwnerReference{
+		{APIVersion: "a/b/c", Kind: "Pod"},
+		{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		{APIVersion: "unknown.com/v1", Kind: "Unknown"},
+	} {
+		_, err := ownerResource(ctx, client, ref)
+		assert.Error(t, err, ref.APIVersion+" "+ref.Kind)
+	}
+}
+
+func TestLabeledOwner(t *testing.T) {
+	client := ownerServer(t)
+	ctx := context.Background()
+
+	owner, err := LabeledOwner(ctx, client, ownedPod("ReplicaSet", "foo-1234", "rs", nil), "team")
+	assert.NoError(t, err)
+	assert.Equal(t, "Deployment", owner.(*unstructured.Unstructured).GetKind(), "owners without the label are passed through")
+	assert.Equal(t, "foo", owner.GetName())
+
+	owner, err = LabeledOwner(ctx, client, ownedPod("ReplicaSet", "foo-1234", "replaced", nil), "team")
+	assert.NoError(t, err)
+	assert.Nil(t, owner, "owners replaced by another object are not followed")
+
+	owner, err = LabeledOwner(ctx, client, ownedPod("ReplicaSet", "foo-1234", "rs", nil), "missing")
+	assert.NoError(t, err)
+	assert.Nil(t, owner)
+
+	_, err = LabeledOwner(ctx, client, ownedPod("ReplicaSet", "missing", "rs", nil), "team")
+	assert.Error(t, err)
+}
+
+func TestApplicationReference(t *testing.T) {
+	refs := []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "foo-1234"},
+		{APIVersion: "nais.io/v1alpha1", Kind: "Application", Name: "foo"},
+	}
+	assert.Equal(t, "foo", applicationReference(refs).Name)
+	assert.Nil(t, applicationReference(refs[:1]))
+	assert.Nil(t, applicationReference([]metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Application"}}))
+}
+
+func TestApplication(t *testing.T) {
+	client := ownerServer(t)
+	ctx := context.Background()
+
+	application, err := Application(ctx, client, ownedPod("ReplicaSet", "foo-1234", "rs", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", application.GetName(), "owner references are followed to the application")
+
+	application, err = Application(ctx, client, ownedPod("ReplicaSet", "foo-1234", "replaced", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, application, "owners replaced by another object are not followed")
+
+	application, err = Application(ctx, client, ownedPod("ReplicaSet", "missing", "rs", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, application)
+
+	application, err = Application(ctx, client, ownedPod("", "", "", map[string]string{"app": "foo"}))
+	assert.NoError(t, err)
+	assert.Nil(t, application, "the app label is not proof of ownership")
+}
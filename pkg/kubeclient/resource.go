@@ -0,0 +1,156 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// ResourceClient reads and writes objects of any resource like the dynamic client, but its requests are
+// cancelled along with their context, which the dynamic client of this client-go version does not support.
+type ResourceClient struct {
+	client     rest.Interface
+	mutex      sync.Mutex
+	discovered map[schema.GroupVersion][]metav1.APIResource
+}
+
+// NewResourceClient returns a client using the specified configuration.
+func NewResourceClient(config *rest.Config) (*ResourceClient, error) {
+	client, err := restClient(config, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceClient{client: client, discovered: make(map[schema.GroupVersion][]metav1.APIResource)}, nil
+}
+
+// Get returns an object. The namespace is empty for cluster scoped resources.
+func (c *ResourceClient) Get(ctx context.Context, resource schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	var obj *unstructured.Unstructured
+	err := traced(ctx, "get", resource, func() (err error) {
+		obj, err = decodeObject(c.client.Get().Context(ctx).AbsPath(resourcePath(resource, namespace, name)...).DoRaw())
+		return err
+	})
+	return obj, err
+}
+
+// List returns the objects of a resource, in a namespace or in all namespaces if the namespace is empty.
+// Only the label selector, limit, continue and resource version options are supported.
+func (c *ResourceClient) List(ctx context.Context, resource schema.GroupVersionResource, namespace string, options metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	var list *unstructured.UnstructuredList
+	err := traced(ctx, "list", resource, func() error {
+		request := c.client.Get().Context(ctx).AbsPath(resourcePath(resource, namespace, "")...)
+		if len(options.LabelSelector) > 0 {
+			request = request.Param("labelSelector", options.LabelSelector)
+		}
+		if options.Limit > 0 {
+			request = request.Param("limit", strconv.FormatInt(options.Limit, 10))
+		}
+		if len(options.Continue) > 0 {
+			request = request.Param("continue", options.Continue)
+		}
+		if len(options.ResourceVersion) > 0 {
+			request = request.Param("resourceVersion", options.ResourceVersion)
+		}
+		data, err := request.DoRaw()
+		if err != nil {
+			return err
+		}
+		obj, err := runtime.Decode(unstructured.UnstructuredJSONScheme, data)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		list, ok = obj.(*unstructured.UnstructuredList)
+		if !ok {
+			return fmt.Errorf("expected a list of %s, got %s", resource.String(), obj.GetObjectKind().GroupVersionKind().Kind)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// Create creates an object in the namespace set in its metadata.
+func (c *ResourceClient) Create(ctx context.Context, resource schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	var created *unstructured.Unstructured
+	err := traced(ctx, "create", resource, func() error {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		created, err = decodeObject(c.client.Post().Context(ctx).AbsPath(resourcePath(resource, obj.GetNamespace(), "")...).Body(data).DoRaw())
+		return err
+	})
+	return created, err
+}
+
+// Update replaces an object, failing with a conflict if it has changed since it was read.
+func (c *ResourceClient) Update(ctx context.Context, resource schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	var updated *unstructured.Unstructured
+	err := traced(ctx, "update", resource, func() error {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		updated, err = decodeObject(c.client.Put().Context(ctx).AbsPath(resourcePath(resource, obj.GetNamespace(), obj.GetName())...).Body(data).DoRaw())
+		return err
+	})
+	return updated, err
+}
+
+// Delete deletes an object. The namespace is empty for cluster scoped resources.
+func (c *ResourceClient) Delete(ctx context.Context, resource schema.GroupVersionResource, namespace, name string) error {
+	return traced(ctx, "delete", resource, func() error {
+		_, err := c.client.Delete().Context(ctx).AbsPath(resourcePath(resource, namespace, name)...).DoRaw()
+		return err
+	})
+}
+
+func decodeObject(data []byte, err error) (*unstructured.Unstructured, error) {
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Client is a dynamic client whose requests for objects are made through a ResourceClient, so that they are
+// cancelled along with their context. Only watches are made through the dynamic client.
+type Client struct {
+	dynamic.Interface
+	resources *ResourceClient
+}
+
+func newClient(config *rest.Config) (*Client, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := NewResourceClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Interface: client, resources: resources}, nil
+}
+
+// Returns the resource client of a client returned by New, NewWatchClient or NewCluster.
+func resourceClient(client dynamic.Interface) (*ResourceClient, error) {
+	switch c := client.(type) {
+	case *Client:
+		return c.resources, nil
+	case *Cluster:
+		return c.resources, nil
+	}
+	return nil, fmt.Errorf("kubernetes client %T does not support cancelling requests", client)
+}
@@ -0,0 +1,99 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestResourceClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/namespaces/default/configmaps/foo":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"foo","namespace":"default"},"data":{"key":"value"}}`)
+		case "GET /api/v1/namespaces/default/configmaps":
+			assert.Equal(t, "team=foo", r.URL.Query().Get("labelSelector"))
+			assert.Equal(t, "10", r.URL.Query().Get("limit"))
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMapList","metadata":{"continue":"next"},"items":[`+
+				`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"foo","namespace":"default"}}]}`)
+		case "POST /api/v1/namespaces/default/configmaps", "PUT /api/v1/namespaces/default/configmaps/bar":
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			w.Write(body)
+		case "DELETE /api/v1/namespaces/default/configmaps/bar":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Success"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewResourceClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	obj, err := client.Get(ctx, configMapResource, "default", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", obj.GetName())
+
+	list, err := client.List(ctx, configMapResource, "default", metav1.ListOptions{LabelSelector: "team=foo", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "next", list.GetContinue())
+
+	obj = &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName("bar")
+	created, err := client.Create(ctx, configMapResource, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", created.GetName())
+	_, err = client.Update(ctx, configMapResource, obj)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Delete(ctx, configMapResource, "default", "bar"))
+
+	_, err = client.Get(ctx, configMapResource, "default", "missing")
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestResourceClientCancellation(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	client, err := New(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ConfigMapData(ctx, client, "default", "foo")
+	assert.Error(t, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request was not cancelled at the context deadline")
+	}
+
+	other, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	_, err = ConfigMapData(ctx, other, "default", "foo")
+	assert.Error(t, err, "clients not created by this package are refused")
+}
@@ -0,0 +1,57 @@
+package kubeclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nais/tobac/pkg/requestlog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Delay before retrying a failed lookup, doubled for every attempt up to the maximum.
+const (
+	lookupInitialBackoff = 100 * time.Millisecond
+	lookupMaxBackoff     = time.Second
+)
+
+// Check whether a lookup failed for reasons that may go away by themselves. Errors not returned
+// by the API server, such as connection failures, are considered transient.
+func transient(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if _, ok := err.(errors.APIStatus); !ok {
+		return true
+	}
+	return errors.IsServerTimeout(err) ||
+		errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) ||
+		errors.IsInternalError(err) ||
+		errors.IsServiceUnavailable(err) ||
+		errors.IsUnexpectedServerError(err)
+}
+
+// Retry a lookup failing with transient errors, with exponential backoff, until it succeeds or the next
+// attempt would not be made before the context deadline.
+func retryLookup(ctx context.Context, backoff time.Duration, lookup func() (metav1.Object, error)) (metav1.Object, error) {
+	for attempt := 1; ; attempt++ {
+		obj, err := lookup()
+		if err == nil || !transient(err) {
+			return obj, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		requestlog.FromContext(ctx).Debugf("lookup attempt %d failed, retrying in %s: %s", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > lookupMaxBackoff {
+			backoff = lookupMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var pods = schema.GroupResource{Resource: "pods"}
+
+func TestTransient(t *testing.T) {
+	assert.True(t, transient(fmt.Errorf("connection refused")))
+	assert.True(t, transient(errors.NewServiceUnavailable("unavailable")))
+	assert.True(t, transient(errors.NewTooManyRequests("slow down", 1)))
+	assert.False(t, transient(errors.NewNotFound(pods, "foo")))
+	assert.False(t, transient(errors.NewForbidden(pods, "foo", fmt.Errorf("denied"))))
+	assert.False(t, transient(context.DeadlineExceeded))
+}
+
+func TestRetryLookup(t *testing.T) {
+	attempts := 0
+	obj, err := retryLookup(context.Background(), time.Millisecond, func() (metav1.Object, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.NewServiceUnavailable("unavailable")
+		}
+		return &metav1.ObjectMeta{Name: "foo"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", obj.GetName())
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	_, err = retryLookup(context.Background(), time.Millisecond, func() (metav1.Object, error) {
+		attempts++
+		return nil, errors.NewNotFound(pods, "foo")
+	})
+	assert.True(t, errors.IsNotFound(err))
+	assert.Equal(t, 1, attempts, "permanent errors are not retried")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	_, err = retryLookup(ctx, 20*time.Millisecond, func() (metav1.Object, error) {
+		attempts++
+		return nil, errors.NewServiceUnavailable("unavailable")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, attempts, "no attempt is made that cannot complete before the deadline")
+}
@@ -0,0 +1,25 @@
+package kubeclient
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var teamResource = schema.GroupVersionResource{
+	Group:    "tobac.nais.io",
+	Version:  "v1alpha1",
+	Resource: "teams",
+}
+
+// Teams lists all Team resources, a page at a time.
+func Teams(ctx context.Context, client dynamic.Interface) ([]unstructured.Unstructured, error) {
+	resources, err := resourceClient(client)
+	if err != nil {
+		return nil, err
+	}
+	items, _, err := listAll(ctx, resources, teamResource)
+	return items, err
+}
@@ -0,0 +1,46 @@
+package kubeclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+func TestTypedClient(t *testing.T) {
+	info, ok := runtime.SerializerInfoForMediaType(scheme.Codecs.SupportedMediaTypes(), contentTypeProtobuf)
+	assert.True(t, ok)
+	encoder := scheme.Codecs.EncoderForVersion(info.Serializer, corev1.SchemeGroupVersion)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept"), contentTypeProtobuf)
+		assert.Equal(t, "/api/v1/namespaces/default/pods/foo", r.URL.Path)
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{"team": "bar"}}}
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		assert.NoError(t, encoder.Encode(pod, w))
+	}))
+	defer server.Close()
+
+	client, err := NewTypedClient(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	lookup, ok := client.lookup(schema.GroupVersionResource{Version: "v1", Resource: "pods"})
+	assert.True(t, ok)
+	obj, err := lookup(context.Background(), "default", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", obj.GetLabels()["team"])
+
+	_, ok = client.lookup(schema.GroupVersionResource{Group: "nais.io", Version: "v1alpha1", Resource: "applications"})
+	assert.False(t, ok, "custom resources are not looked up by the typed client")
+
+	_, ok = client.lookup(schema.GroupVersionResource{Version: "v1", Resource: "secrets"})
+	assert.False(t, ok, "secrets are left to the metadata client")
+}
@@ -0,0 +1,352 @@
+// The LDAP client implements the small subset of RFC 4511 the provider needs: simple bind, StartTLS and paged
+// subtree searches. Maintained clients such as go-ldap require newer versions of testify and golang.org/x packages
+// than this module is pinned to, while the BER encoding used here has no dependencies of its own.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Protocol operations of RFC 4511 used by the provider.
+const (
+	applicationBindRequest           = 0
+	applicationBindResponse          = 1
+	applicationUnbindRequest         = 2
+	applicationSearchRequest         = 3
+	applicationSearchResultEntry     = 4
+	applicationSearchResultDone      = 5
+	applicationSearchResultReference = 19
+	applicationExtendedRequest       = 23
+	applicationExtendedResponse      = 24
+)
+
+// Extended operation of RFC 4511 upgrading the connection to TLS.
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Simple paged results control of RFC 2696, required to retrieve more entries than the server's size limit.
+const pagedResultsControl = "1.2.840.113556.1.4.319"
+
+// A connection to an LDAP server, used by a single goroutine at a time.
+type conn struct {
+	net.Conn
+	host      string
+	tls       bool
+	messageID int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Connect to an LDAP server given as 'ldap://host[:port]' or 'ldaps://host[:port]', verifying ldaps servers
+// with the TLS configuration. Connecting and every later request fail when the context is done.
+func dial(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "ldap":
+		if len(port) == 0 {
+			port = "389"
+		}
+	case "ldaps":
+		if len(port) == 0 {
+			port = "636"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme '%s'", u.Scheme)
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+
+	var c net.Conn
+	dialer := &net.Dialer{}
+	if u.Scheme == "ldaps" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: serverConfig(tlsConfig, u.Hostname())}
+		c, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		c, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = c.SetDeadline(deadline)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	conn := &conn{Conn: c, host: u.Hostname(), tls: u.Scheme == "ldaps", done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-conn.done:
+		}
+	}()
+	return conn, nil
+}
+
+// Returns a copy of the TLS configuration verifying the specified server.
+func serverConfig(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// Upgrade the connection to TLS, verifying the server with the TLS configuration.
+func (c *conn) startTLS(config *tls.Config) error {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, applicationExtendedRequest, nil, "extended request")
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, startTLSOID, "request name"))
+
+	id, err := c.send(op, nil)
+	if err != nil {
+		return err
+	}
+	response, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if response.Tag != applicationExtendedResponse {
+		return fmt.Errorf("unexpected response to StartTLS request")
+	}
+	err = result(response)
+	if err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.Conn, serverConfig(config, c.host))
+	err = tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+	c.Conn = tlsConn
+	c.tls = true
+	return nil
+}
+
+// Close unbinds and closes the connection.
+func (c *conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.send(ber.Encode(ber.ClassApplication, ber.TypePrimitive, applicationUnbindRequest, nil, "unbind request"), nil)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// Send a protocol operation with optional controls. Returns the message ID of the request.
+func (c *conn) send(op, controls *ber.Packet) (int64, error) {
+	c.messageID++
+	message := ber.NewSequence("LDAP message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.messageID, "message ID"))
+	message.AppendChild(op)
+	if controls != nil {
+		message.AppendChild(controls)
+	}
+	_, err := c.Write(message.Bytes())
+	return c.messageID, err
+}
+
+// Read the next response to a request. Returns the protocol operation and the controls, which may be nil.
+func (c *conn) receive(messageID int64) (*ber.Packet, *ber.Packet, error) {
+	message, err := ber.ReadPacket(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(message.Children) < 2 {
+		return nil, nil, fmt.Errorf("malformed LDAP message")
+	}
+	if id, ok := message.Children[0].Value.(int64); !ok || id != messageID {
+		return nil, nil, fmt.Errorf("unexpected LDAP message ID %v", message.Children[0].Value)
+	}
+	var controls *ber.Packet
+	if len(message.Children) > 2 {
+		controls = message.Children[2]
+	}
+	return message.Children[1], controls, nil
+}
+
+// Returns an error unless the LDAP result of a response reports success.
+func result(op *ber.Packet) error {
+	if len(op.Children) < 3 {
+		return fmt.Errorf("malformed LDAP result")
+	}
+	code, ok := op.Children[0].Value.(int64)
+	if !ok {
+		return fmt.Errorf("malformed LDAP result code")
+	}
+	if code != 0 {
+		return fmt.Errorf("LDAP result code %d: %s", code, op.Children[2].Data.String())
+	}
+	return nil
+}
+
+// Authenticate with a simple bind.
+func (c *conn) bind(dn, password string) error {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, applicationBindRequest, nil, "bind request")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "version"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "name"))
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, password, "simple authentication"))
+
+	id, err := c.send(op, nil)
+	if err != nil {
+		return err
+	}
+	response, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if response.Tag != applicationBindResponse {
+		return fmt.Errorf("unexpected response to bind request")
+	}
+	return result(response)
+}
+
+// An entry returned by a search, with attribute values by lowercase attribute name.
+type entry struct {
+	DN         string
+	attributes map[string][][]byte
+}
+
+// Returns the first value of an attribute, or an empty string if the entry does not have the attribute.
+func (e *entry) value(attribute string) string {
+	return string(e.raw(attribute))
+}
+
+func (e *entry) raw(attribute string) []byte {
+	values := e.attributes[strings.ToLower(attribute)]
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// Search the subtree of the base DN, retrieving entries in pages of the specified size.
+func (c *conn) search(baseDN, filter string, attributes []string, pageSize int) ([]*entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter '%s': %s", filter, err)
+	}
+
+	entries := make([]*entry, 0)
+	cookie := ""
+	for {
+		op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, applicationSearchRequest, nil, "search request")
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, baseDN, "base object"))
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 2, "scope: whole subtree"))
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "never dereference aliases"))
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "size limit"))
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "time limit"))
+		op.AppendChild(ber.NewLDAPBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "types only"))
+		op.AppendChild(compiled)
+		list := ber.NewSequence("attributes")
+		for _, attribute := range attributes {
+			if len(attribute) > 0 {
+				list.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "attribute"))
+			}
+		}
+		op.AppendChild(list)
+
+		id, err := c.send(op, pagingControl(pageSize, cookie))
+		if err != nil {
+			return nil, err
+		}
+
+		done := false
+		for !done {
+			response, controls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			switch response.Tag {
+			case applicationSearchResultEntry:
+				e, err := parseEntry(response)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, e)
+			case applicationSearchResultReference:
+			case applicationSearchResultDone:
+				err = result(response)
+				if err != nil {
+					return nil, err
+				}
+				cookie = pagingCookie(controls)
+				done = true
+			default:
+				return nil, fmt.Errorf("unexpected response to search request")
+			}
+		}
+
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+func pagingControl(pageSize int, cookie string) *ber.Packet {
+	value := ber.NewSequence("paging")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, pageSize, "size"))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, "cookie"))
+
+	control := ber.NewSequence("control")
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, pagedResultsControl, "control type"))
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), "control value"))
+
+	controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "controls")
+	controls.AppendChild(control)
+	return controls
+}
+
+// Returns the cookie of the paged results control, which is empty once the last page has been returned.
+func pagingCookie(controls *ber.Packet) string {
+	if controls == nil {
+		return ""
+	}
+	for _, control := range controls.Children {
+		if len(control.Children) < 2 || control.Children[0].Data.String() != pagedResultsControl {
+			continue
+		}
+		value, err := ber.DecodePacketErr(control.Children[len(control.Children)-1].Data.Bytes())
+		if err != nil || len(value.Children) < 2 {
+			return ""
+		}
+		return value.Children[1].Data.String()
+	}
+	return ""
+}
+
+func parseEntry(op *ber.Packet) (*entry, error) {
+	if len(op.Children) < 2 {
+		return nil, fmt.Errorf("malformed search result entry")
+	}
+	e := &entry{
+		DN:         op.Children[0].Data.String(),
+		attributes: make(map[string][][]byte),
+	}
+	for _, attribute := range op.Children[1].Children {
+		if len(attribute.Children) < 2 {
+			return nil, fmt.Errorf("malformed attribute in search result entry '%s'", e.DN)
+		}
+		name := strings.ToLower(attribute.Children[0].Data.String())
+		for _, value := range attribute.Children[1].Children {
+			e.attributes[name] = append(e.attributes[name], value.Data.Bytes())
+		}
+	}
+	return e, nil
+}
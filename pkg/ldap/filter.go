@@ -0,0 +1,227 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Filter choices of RFC 4511.
+const (
+	filterAnd             = 0
+	filterOr              = 1
+	filterNot             = 2
+	filterEqualityMatch   = 3
+	filterSubstrings      = 4
+	filterGreaterOrEqual  = 5
+	filterLessOrEqual     = 6
+	filterPresent         = 7
+	filterApproxMatch     = 8
+	filterExtensibleMatch = 9
+)
+
+// Compile a filter in the string representation of RFC 4515, e.g. '(&(objectClass=group)(cn=team-*))'.
+func compileFilter(filter string) (*ber.Packet, error) {
+	packet, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected '%s' after filter", rest)
+	}
+	return packet, nil
+}
+
+// Parse a parenthesized filter. Returns the rest of the string following it.
+func parseFilter(s string) (*ber.Packet, string, error) {
+	if !strings.HasPrefix(s, "(") || len(s) < 2 {
+		return nil, "", fmt.Errorf("filter must be enclosed in parentheses")
+	}
+	s = s[1:]
+
+	var packet *ber.Packet
+	switch s[0] {
+	case '&', '|', '!':
+		operator := s[0]
+		tag := map[byte]ber.Tag{'&': filterAnd, '|': filterOr, '!': filterNot}[operator]
+		packet = ber.Encode(ber.ClassContext, ber.TypeConstructed, tag, nil, "filter")
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			var child *ber.Packet
+			var err error
+			child, s, err = parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			packet.AppendChild(child)
+		}
+		if tag == filterNot && len(packet.Children) != 1 {
+			return nil, "", fmt.Errorf("'!' must be followed by a single filter")
+		}
+		if len(packet.Children) == 0 {
+			return nil, "", fmt.Errorf("'%c' must be followed by one or more filters", operator)
+		}
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("missing ')'")
+		}
+		var err error
+		packet, err = parseItem(s[:end])
+		if err != nil {
+			return nil, "", err
+		}
+		s = s[end:]
+	}
+
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", fmt.Errorf("missing ')'")
+	}
+	return packet, s[1:], nil
+}
+
+// Parse a filter item such as 'cn=foo', 'cn=foo*', 'cn=*', 'uidNumber>=1000' or 'memberOf:1.2.840.113556.1.4.1941:=dn'.
+func parseItem(item string) (*ber.Packet, error) {
+	i := strings.IndexByte(item, '=')
+	if i <= 0 {
+		return nil, fmt.Errorf("'%s' is not a valid filter item", item)
+	}
+	attribute, value := item[:i], item[i+1:]
+
+	if strings.HasSuffix(attribute, ":") {
+		return extensibleMatch(strings.TrimSuffix(attribute, ":"), value)
+	}
+
+	tag := ber.Tag(filterEqualityMatch)
+	switch attribute[len(attribute)-1] {
+	case '~':
+		tag = filterApproxMatch
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	}
+	if tag != filterEqualityMatch {
+		attribute = attribute[:len(attribute)-1]
+	}
+	if len(attribute) == 0 {
+		return nil, fmt.Errorf("'%s' has no attribute", item)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return ber.NewString(ber.ClassContext, ber.TypePrimitive, filterPresent, attribute, "present"), nil
+	}
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		return substrings(attribute, value)
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	packet := ber.Encode(ber.ClassContext, ber.TypeConstructed, tag, nil, "attribute value assertion")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "attribute"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, unescaped, "value"))
+	return packet, nil
+}
+
+func substrings(attribute, value string) (*ber.Packet, error) {
+	parts := strings.Split(value, "*")
+	list := ber.NewSequence("substrings")
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		unescaped, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := ber.Tag(1) // any
+		if i == 0 {
+			tag = 0 // initial
+		} else if i == len(parts)-1 {
+			tag = 2 // final
+		}
+		list.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, tag, unescaped, "substring"))
+	}
+
+	packet := ber.Encode(ber.ClassContext, ber.TypeConstructed, filterSubstrings, nil, "substrings")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "attribute"))
+	packet.AppendChild(list)
+	return packet, nil
+}
+
+// Parse an extensible match given as ATTRIBUTE[:dn][:RULE] or [:dn]:RULE, with the trailing ':' removed.
+func extensibleMatch(attribute, value string) (*ber.Packet, error) {
+	parts := strings.Split(attribute, ":")
+	attribute = parts[0]
+	var rule string
+	dnAttributes := false
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else {
+			rule = part
+		}
+	}
+	if len(attribute) == 0 && len(rule) == 0 {
+		return nil, fmt.Errorf("extensible match requires an attribute or a matching rule")
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	packet := ber.Encode(ber.ClassContext, ber.TypeConstructed, filterExtensibleMatch, nil, "extensible match")
+	if len(rule) > 0 {
+		packet.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, rule, "matching rule"))
+	}
+	if len(attribute) > 0 {
+		packet.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 2, attribute, "type"))
+	}
+	packet.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 3, unescaped, "match value"))
+	if dnAttributes {
+		packet.AppendChild(ber.NewLDAPBoolean(ber.ClassContext, ber.TypePrimitive, 4, true, "dn attributes"))
+	}
+	return packet, nil
+}
+
+// Decode the '\XX' escapes of a filter value.
+func unescapeFilterValue(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("incomplete escape in '%s'", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in '%s'", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// Escape a value for use in a filter, such as a DN containing parentheses.
+func escapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '\\' || c == '*' || c == '(' || c == ')' || c == 0 || c >= 0x80 {
+			fmt.Fprintf(&b, `\%02x`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+)
+
+const pageSize = 500
+
+// Attributes maps LDAP attributes onto team fields.
+type Attributes struct {
+	ID          string // Team ID, e.g. sAMAccountName or cn
+	Title       string // Team display name
+	Description string // Team description
+	Group       string // Group identifier as found in Kubernetes user groups, e.g. objectGUID or cn
+	Username    string // Kubernetes username of group members, e.g. userPrincipalName. Leave empty to skip member lookup.
+}
+
+type Config struct {
+	URL          string
+	CAFile       string // PEM bundle of CA certificates verifying the server, instead of the system roots
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	GroupFilter  string
+	Attributes   Attributes
+}
+
+// Provider retrieves teams from LDAP or Active Directory groups matching a filter.
+type Provider struct {
+	config Config
+}
+
+func New(config Config) *Provider {
+	return &Provider{
+		config: config,
+	}
+}
+
+// Returns the TLS configuration verifying the LDAP server.
+func (p *Provider) tlsConfig() (*tls.Config, error) {
+	if len(p.config.CAFile) == 0 {
+		return &tls.Config{}, nil
+	}
+	data, err := ioutil.ReadFile(p.config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%s'", p.config.CAFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// Connect and bind to the LDAP server. Plain connections are upgraded with StartTLS before binding
+// with a password, which is never sent in cleartext. The connection is closed when the context is done.
+func (p *Provider) connect(ctx context.Context) (*conn, error) {
+	tlsConfig, err := p.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("while loading LDAP CA bundle: %s", err)
+	}
+
+	conn, err := dial(ctx, p.config.URL, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !conn.tls && len(p.config.BindPassword) > 0 {
+		err = conn.startTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS is required to bind with a password: %s", err)
+		}
+	}
+
+	if len(p.config.BindDN) > 0 {
+		err = conn.bind(p.config.BindDN, p.config.BindPassword)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("bind as '%s': %s", p.config.BindDN, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (p *Provider) search(conn *conn, filter string, attributes []string) ([]*entry, error) {
+	return conn.search(p.config.BaseDN, filter, attributes, pageSize)
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	conn, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attrs := p.config.Attributes
+	entries, err := p.search(conn, p.config.GroupFilter, []string{attrs.ID, attrs.Title, attrs.Description, attrs.Group})
+	if err != nil {
+		return nil, fmt.Errorf("search for groups: %s", err)
+	}
+
+	teams := make(map[string]azure.Team)
+	for _, entry := range entries {
+		team := azure.Team{
+			AzureUUID:   attributeValue(entry, attrs.Group),
+			ID:          strings.ToLower(entry.value(attrs.ID)),
+			Title:       entry.value(attrs.Title),
+			Description: entry.value(attrs.Description),
+		}
+
+		if len(attrs.Username) > 0 {
+			team.Members, err = p.members(conn, entry.DN)
+			if err != nil {
+				return nil, fmt.Errorf("search for members of '%s': %s", entry.DN, err)
+			}
+		}
+
+		if !team.Valid() {
+			log.Errorf("ldap: invalid team '%s'", entry.DN)
+			continue
+		}
+		teams[team.ID] = team
+		log.Debugf("ldap: add team '%s' with id '%s'", team.ID, team.AzureUUID)
+	}
+
+	return teams, nil
+}
+
+// Retrieve the usernames of all direct and nested members of a group.
+func (p *Provider) members(conn *conn, groupDN string) ([]string, error) {
+	// LDAP_MATCHING_RULE_IN_CHAIN resolves nested group membership in Active Directory.
+	filter := fmt.Sprintf("(memberOf:1.2.840.113556.1.4.1941:=%s)", escapeFilter(groupDN))
+	entries, err := p.search(conn, filter, []string{p.config.Attributes.Username})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		username := entry.value(p.config.Attributes.Username)
+		if len(username) > 0 {
+			members = append(members, username)
+		}
+	}
+	return members, nil
+}
+
+// Retrieve an attribute value as a string. Active Directory object GUIDs are binary,
+// and are formatted the same way as they appear in Azure AD group claims.
+func attributeValue(entry *entry, attribute string) string {
+	if !strings.EqualFold(attribute, "objectGUID") {
+		return entry.value(attribute)
+	}
+	b := entry.raw(attribute)
+	if len(b) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x",
+		b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6], b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15])
+}
@@ -0,0 +1,242 @@
+package ldap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/assert"
+)
+
+// Binary objectGUID of an Active Directory group, and its string form as found in Azure AD group claims.
+var (
+	groupGUID       = []byte{0x67, 0x45, 0x23, 0x01, 0xab, 0x89, 0xef, 0xcd, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	groupGUIDString = "01234567-89ab-cdef-0123-456789abcdef"
+)
+
+func message(id int64, op *ber.Packet, controls *ber.Packet) []byte {
+	packet := ber.NewSequence("LDAP message")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "message ID"))
+	packet.AppendChild(op)
+	if controls != nil {
+		packet.AppendChild(controls)
+	}
+	return packet.Bytes()
+}
+
+func ldapResult(tag ber.Tag, code int, diagnostic string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "result code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "diagnostic message"))
+	return op
+}
+
+func searchEntry(dn string, attributes map[string]string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, applicationSearchResultEntry, nil, "entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+	list := ber.NewSequence("attributes")
+	for name, value := range attributes {
+		attribute := ber.NewSequence("attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		attribute.AppendChild(values)
+		list.AppendChild(attribute)
+	}
+	op.AppendChild(list)
+	return op
+}
+
+// Returns a self-signed certificate for 127.0.0.1, and a file containing it.
+func certificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, path
+}
+
+// Serve a directory with two groups, returned one per page, whose members are found with nested membership.
+// Binding requires the connection to have been upgraded with StartTLS, which is refused if cert is nil.
+func serveDirectory(t *testing.T, c net.Conn, cert *tls.Certificate) {
+	defer func() { c.Close() }()
+	secure := false
+	for {
+		request, err := ber.ReadPacket(c)
+		if err != nil {
+			return
+		}
+		id := request.Children[0].Value.(int64)
+		op := request.Children[1]
+		switch op.Tag {
+		case applicationExtendedRequest:
+			if cert == nil {
+				c.Write(message(id, ldapResult(applicationExtendedResponse, 2, "StartTLS not supported"), nil))
+				continue
+			}
+			c.Write(message(id, ldapResult(applicationExtendedResponse, 0, ""), nil))
+			c = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{*cert}})
+			secure = true
+		case applicationBindRequest:
+			assert.True(t, secure, "passwords are only sent over TLS")
+			code := 0
+			if op.Children[1].Data.String() != "cn=tobac" || op.Children[2].Data.String() != "secret" {
+				code = 49 // invalid credentials
+			}
+			c.Write(message(id, ldapResult(applicationBindResponse, code, "bind"), nil))
+		case applicationSearchRequest:
+			filter := op.Children[6]
+			if filter.Tag == filterExtensibleMatch {
+				group := filter.Children[2].Data.String()
+				c.Write(message(id, searchEntry("cn=user", map[string]string{"userPrincipalName": "user@" + group}), nil))
+				c.Write(message(id, ldapResult(applicationSearchResultDone, 0, ""), nil))
+				continue
+			}
+			cookie := pagingCookie(request.Children[2])
+			if len(cookie) == 0 {
+				c.Write(message(id, searchEntry("cn=first", map[string]string{"cn": "First", "displayName": "First team", "objectGUID": string(groupGUID)}), nil))
+				c.Write(message(id, ldapResult(applicationSearchResultDone, 0, ""), pagingControl(pageSize, "second")))
+			} else {
+				assert.Equal(t, "second", cookie)
+				c.Write(message(id, searchEntry("cn=second(old)", map[string]string{"cn": "Second", "objectGUID": string(groupGUID[:8])}), nil))
+				c.Write(message(id, ldapResult(applicationSearchResultDone, 0, ""), pagingControl(pageSize, "")))
+			}
+		case applicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func listen(t *testing.T, serve func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func TestTeams(t *testing.T) {
+	cert, caFile := certificate(t)
+	url := listen(t, func(c net.Conn) { serveDirectory(t, c, &cert) })
+	config := Config{
+		URL:          url,
+		CAFile:       caFile,
+		BindDN:       "cn=tobac",
+		BindPassword: "secret",
+		BaseDN:       "dc=example,dc=com",
+		GroupFilter:  "(objectClass=group)",
+		Attributes: Attributes{
+			ID:       "cn",
+			Title:    "displayName",
+			Group:    "objectGUID",
+			Username: "userPrincipalName",
+		},
+	}
+
+	teams, err := New(config).Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, teams, 2, "groups are retrieved from every page")
+	assert.Equal(t, groupGUIDString, teams["first"].AzureUUID)
+	assert.Equal(t, "First team", teams["first"].Title)
+	assert.Equal(t, []string{"user@cn=first"}, teams["first"].Members)
+	assert.Empty(t, teams["second"].AzureUUID, "malformed GUIDs are ignored")
+	assert.Equal(t, []string{"user@cn=second(old)"}, teams["second"].Members, "group DNs are escaped in member filters")
+
+	config.BindPassword = "wrong"
+	_, err = New(config).Teams(context.Background())
+	assert.Error(t, err)
+
+	config.CAFile = ""
+	_, err = New(config).Teams(context.Background())
+	assert.Error(t, err, "the server certificate is verified")
+}
+
+func TestTeamsStartTLSRefused(t *testing.T) {
+	url := listen(t, func(c net.Conn) { serveDirectory(t, c, nil) })
+	config := Config{
+		URL:          url,
+		BindDN:       "cn=tobac",
+		BindPassword: "secret",
+		GroupFilter:  "(objectClass=group)",
+	}
+	_, err := New(config).Teams(context.Background())
+	assert.Error(t, err, "passwords are not sent without TLS")
+}
+
+func TestTeamsDeadline(t *testing.T) {
+	// The server accepts connections, but never answers.
+	url := listen(t, func(c net.Conn) {
+		time.Sleep(time.Second)
+		c.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := New(Config{URL: url, BindDN: "cn=tobac", GroupFilter: "(objectClass=group)"}).Teams(ctx)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestCompileFilter(t *testing.T) {
+	packet, err := compileFilter("(&(objectClass=group)(!(cn=old*))(memberOf:1.2.840.113556.1.4.1941:=cn=a\\28b\\29))")
+	assert.NoError(t, err)
+	assert.Equal(t, ber.Tag(filterAnd), packet.Tag)
+	assert.Len(t, packet.Children, 3)
+	assert.Equal(t, ber.Tag(filterEqualityMatch), packet.Children[0].Tag)
+	assert.Equal(t, ber.Tag(filterNot), packet.Children[1].Tag)
+	assert.Equal(t, ber.Tag(filterSubstrings), packet.Children[1].Children[0].Tag)
+	match := packet.Children[2]
+	assert.Equal(t, ber.Tag(filterExtensibleMatch), match.Tag)
+	assert.Equal(t, "1.2.840.113556.1.4.1941", match.Children[0].Data.String())
+	assert.Equal(t, "memberOf", match.Children[1].Data.String())
+	assert.Equal(t, "cn=a(b)", match.Children[2].Data.String())
+
+	packet, err = compileFilter("(cn=*)")
+	assert.NoError(t, err)
+	assert.Equal(t, ber.Tag(filterPresent), packet.Tag)
+
+	for _, filter := range []string{"", "cn=foo", "(cn=foo", "(&)", "(!(a=b)(c=d))", "(=foo)", "(cn=foo)x", "(cn=\\4)"} {
+		_, err = compileFilter(filter)
+		assert.Error(t, err, filter)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	assert.Equal(t, `cn=team \28old\29\2a,dc=example`, escapeFilter("cn=team (old)*,dc=example"))
+	unescaped, err := unescapeFilterValue(escapeFilter("cn=æ\\"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cn=æ\\", unescaped)
+}
@@ -0,0 +1,49 @@
+package logfields
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Hook attaches a static set of fields to every log entry.
+type Hook struct {
+	fields log.Fields
+}
+
+// Parse parses fields on the form key=value.
+func Parse(pairs []string) (log.Fields, error) {
+	fields := make(log.Fields, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("log field '%s' must be on the form key=value", pair)
+		}
+		fields[kv[0]] = kv[1]
+	}
+	return fields, nil
+}
+
+// NewHook returns a hook adding the specified fields to every entry.
+func NewHook(fields log.Fields) *Hook {
+	return &Hook{fields: fields}
+}
+
+func (h *Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the fields to the entry. Fields set on the entry itself take precedence.
+// The entry's data may be shared with other entries, so it is copied instead of modified.
+func (h *Hook) Fire(entry *log.Entry) error {
+	data := make(log.Fields, len(entry.Data)+len(h.fields))
+	for k, v := range h.fields {
+		data[k] = v
+	}
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	entry.Data = data
+	return nil
+}
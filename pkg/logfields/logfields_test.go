@@ -0,0 +1,38 @@
+package logfields
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	fields, err := Parse([]string{"cluster=prod-gcp", "tenant=nav", "note=a=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, log.Fields{"cluster": "prod-gcp", "tenant": "nav", "note": "a=b"}, fields)
+
+	_, err = Parse([]string{"cluster"})
+	assert.Error(t, err)
+	_, err = Parse([]string{"=prod-gcp"})
+	assert.Error(t, err)
+}
+
+func TestHook(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New()
+	logger.Out = buf
+	logger.Formatter = &log.JSONFormatter{}
+	logger.AddHook(NewHook(log.Fields{"cluster": "prod-gcp", "tenant": "nav"}))
+
+	entry := logger.WithField("tenant", "override")
+	entry.Info("hello")
+
+	record := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "prod-gcp", record["cluster"])
+	assert.Equal(t, "override", record["tenant"])
+	assert.Len(t, entry.Data, 1)
+}
@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,21 +12,161 @@ import (
 )
 
 var (
-	Admitted = prometheus.NewCounter(prometheus.CounterOpts{
+	Admitted = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name:      "admitted",
 		Namespace: "tobac",
-		Help:      "number of requests admitted",
-	})
-	Denied = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "number of requests admitted, by the cluster they are from",
+	}, []string{"cluster"})
+	Denied = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name:      "denied",
 		Namespace: "tobac",
-		Help:      "number of requests denied",
+		Help:      "number of requests denied, by reason code and the cluster they are from",
+	}, []string{"reason", "cluster"})
+	ClusterInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "cluster_info",
+		Namespace: "tobac",
+		Help:      "cluster and environment this instance makes decisions for",
+	}, []string{"cluster", "environment"})
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "build_info",
+		Namespace: "tobac",
+		Help:      "version of the running build",
+	}, []string{"version", "revision", "build_date", "go_version"})
+	TeamProviderActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "team_provider_active",
+		Namespace: "tobac",
+		Help:      "set to 1 for the team provider currently backing decisions",
+	}, []string{"provider"})
+	AzureTokenExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "azure_token_expiry_timestamp_seconds",
+		Namespace: "tobac",
+		Help:      "unix time at which the cached Microsoft Graph access token expires",
+	})
+	TeamSyncRejected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "team_sync_rejected",
+		Namespace: "tobac",
+		Help:      "set to 1 when the last team list was rejected for being drastically smaller than the cached one",
+	})
+	TeamCacheUpdated = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "team_cache_updated_timestamp_seconds",
+		Namespace: "tobac",
+		Help:      "unix time of the last update of the team cache",
+	})
+	TeamsCached = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "teams_cached",
+		Namespace: "tobac",
+		Help:      "number of teams in the team cache",
+	})
+	TeamSyncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "team_sync_last_success_timestamp_seconds",
+		Namespace: "tobac",
+		Help:      "unix time of the last successful team synchronization, by this replica or the leader whose snapshot it read",
+	})
+	TeamSyncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "team_sync_errors_total",
+		Namespace: "tobac",
+		Help:      "number of team synchronizations that failed or were rejected",
+	})
+	RateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "rate_limited",
+		Namespace: "tobac",
+		Help:      "number of requests denied because the user exceeded the rate limit",
+	})
+	TeamRenamed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "team_renamed",
+		Namespace: "tobac",
+		Help:      "number of teams whose ID changed while their group stayed the same",
+	})
+	Unauthenticated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "unauthenticated",
+		Namespace: "tobac",
+		Help:      "number of admission requests refused for lacking a valid client certificate",
+	})
+	Malformed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "malformed",
+		Namespace: "tobac",
+		Help:      "number of admission requests that could not be decoded",
+	})
+	DecisionResourcesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "decision_resources_dropped",
+		Namespace: "tobac",
+		Help:      "number of admission decisions not recorded as AdmissionDecision resources because too many were waiting to be written",
+	})
+	DecisionStreamDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "decision_stream_dropped",
+		Namespace: "tobac",
+		Help:      "number of admission decisions dropped because the decision stream reader fell behind",
+	})
+	KubernetesAuthFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "kubernetes_authentication_failures",
+		Namespace: "tobac",
+		Help:      "number of Kubernetes API server requests refused as unauthorized",
+	})
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "leader",
+		Namespace: "tobac",
+		Help:      "1 if this replica holds the leader election lease and synchronizes teams",
+	})
+	Degraded = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:      "degraded",
+		Namespace: "tobac",
+		Help:      "1 if decisions are based on a team cache that has not been synchronized for too long",
+	}, func() float64 {
+		if degradedCheck != nil && degradedCheck() {
+			return 1
+		}
+		return 0
 	})
 )
 
+// Maximum time to wait for in-flight requests when stopping the metrics server.
+const shutdownTimeout = 5 * time.Second
+
+// ReadinessCheck returns an error if this instance should not receive traffic.
+type ReadinessCheck func() error
+
+var readinessCheck ReadinessCheck
+
+var degradedCheck func() bool
+
+var handlers = make(map[string]http.Handler)
+
+// Handle registers an additional handler on the metrics server. Must be called before Serve.
+func Handle(pattern string, handler http.Handler) {
+	handlers[pattern] = handler
+}
+
 func init() {
 	prometheus.MustRegister(Admitted)
 	prometheus.MustRegister(Denied)
+	prometheus.MustRegister(ClusterInfo)
+	prometheus.MustRegister(BuildInfo)
+	prometheus.MustRegister(TeamProviderActive)
+	prometheus.MustRegister(TeamCacheUpdated)
+	prometheus.MustRegister(AzureTokenExpiry)
+	prometheus.MustRegister(TeamSyncRejected)
+	prometheus.MustRegister(TeamsCached)
+	prometheus.MustRegister(TeamSyncLastSuccess)
+	prometheus.MustRegister(TeamSyncErrors)
+	prometheus.MustRegister(TeamRenamed)
+	prometheus.MustRegister(RateLimited)
+	prometheus.MustRegister(Unauthenticated)
+	prometheus.MustRegister(Malformed)
+	prometheus.MustRegister(Degraded)
+	prometheus.MustRegister(Leader)
+	prometheus.MustRegister(KubernetesAuthFailures)
+	prometheus.MustRegister(DecisionStreamDropped)
+	prometheus.MustRegister(DecisionResourcesDropped)
+}
+
+// SetReadinessCheck configures a check that must pass for the readiness endpoint to report success.
+func SetReadinessCheck(check ReadinessCheck) {
+	readinessCheck = check
+}
+
+// SetDegradedCheck configures how to determine whether this instance is running in degraded mode.
+func SetDegradedCheck(check func() bool) {
+	degradedCheck = check
 }
 
 func isAlive(w http.ResponseWriter, r *http.Request) {
@@ -32,18 +174,57 @@ func isAlive(w http.ResponseWriter, r *http.Request) {
 }
 
 func isReady(w http.ResponseWriter, r *http.Request) {
+	if readinessCheck != nil {
+		if err := readinessCheck(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Not ready: %s", err)
+			return
+		}
+	}
 	fmt.Fprintf(w, "Ready.")
 }
 
-// Serve health and metric requests forever.
-func Serve(addr, metrics, ready, alive string) {
+// Handler serves metrics, health checks and the additional handlers. The handlers must be registered first.
+func Handler(metrics, ready, alive string) *http.ServeMux {
 	h := http.NewServeMux()
 	h.Handle(metrics, promhttp.Handler())
 	h.HandleFunc(ready, isReady)
 	h.HandleFunc(alive, isAlive)
-	log.Infof("Metrics and status server started on %s", addr)
+	for pattern, handler := range handlers {
+		h.Handle(pattern, handler)
+		log.Infof("Serving %s", pattern)
+	}
 	log.Infof("Serving metrics on %s", metrics)
 	log.Infof("Serving readiness check on %s", ready)
 	log.Infof("Serving liveness check on %s", alive)
-	log.Info(http.ListenAndServe(addr, h))
+	return h
+}
+
+// Patterns returns the patterns of the additional handlers.
+func Patterns() []string {
+	patterns := make([]string, 0, len(handlers))
+	for pattern := range handlers {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// Serve health and metric requests until the context is done.
+func Serve(ctx context.Context, addr, metrics, ready, alive string) {
+	h := Handler(metrics, ready, alive)
+	log.Infof("Metrics and status server started on %s", addr)
+	server := &http.Server{Addr: addr, Handler: h}
+	go func() {
+		<-ctx.Done()
+		shutdownContext, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownContext)
+	}()
+
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Error(err)
+		return
+	}
+	log.Infof("Metrics and status server stopped")
 }
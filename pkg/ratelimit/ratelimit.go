@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Users without requests for this long are forgotten, and start over with a full burst.
+const idleTimeout = 10 * time.Minute
+
+// Limiter limits the request rate of each user independently.
+type Limiter struct {
+	limit  rate.Limit
+	burst  int
+	exempt []string
+	mutex  sync.Mutex
+	users  map[string]*user
+	pruned time.Time
+}
+
+type user struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// New returns a limiter allowing each user the specified number of requests per second,
+// with bursts of up to burst requests. Users whose name starts with one of the exempt prefixes are not limited.
+func New(perSecond float64, burst int, exempt []string) *Limiter {
+	return &Limiter{
+		limit:  rate.Limit(perSecond),
+		burst:  burst,
+		exempt: exempt,
+		users:  make(map[string]*user),
+		pruned: time.Now(),
+	}
+}
+
+// Allow reports whether the user may make a request now.
+func (l *Limiter) Allow(username string) bool {
+	return l.allow(username, time.Now())
+}
+
+func (l *Limiter) allow(username string, now time.Time) bool {
+	for _, prefix := range l.exempt {
+		if strings.HasPrefix(username, prefix) {
+			return true
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.pruned) > idleTimeout {
+		for name, u := range l.users {
+			if now.Sub(u.seen) > idleTimeout {
+				delete(l.users, name)
+			}
+		}
+		l.pruned = now
+	}
+
+	u, ok := l.users[username]
+	if !ok {
+		u = &user{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.users[username] = u
+	}
+	u.seen = now
+
+	return u.limiter.AllowN(now, 1)
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := New(1, 2, nil)
+	now := time.Now()
+
+	assert.True(t, limiter.allow("controller", now))
+	assert.True(t, limiter.allow("controller", now))
+	assert.False(t, limiter.allow("controller", now), "burst is exhausted")
+	assert.True(t, limiter.allow("user", now), "users are limited independently")
+
+	assert.True(t, limiter.allow("controller", now.Add(time.Second)), "tokens are replenished")
+
+	limiter.allow("user", now.Add(2*idleTimeout))
+	assert.NotContains(t, limiter.users, "controller", "idle users are forgotten")
+}
+
+func TestLimiterExempt(t *testing.T) {
+	limiter := New(1, 1, []string{"system:kube-controller-manager", "system:serviceaccount:kube-system:"})
+	now := time.Now()
+
+	for _, username := range []string{"system:kube-controller-manager", "system:serviceaccount:kube-system:generic-garbage-collector"} {
+		assert.True(t, limiter.allow(username, now))
+		assert.True(t, limiter.allow(username, now), "%s is exempt", username)
+	}
+
+	assert.True(t, limiter.allow("system:serviceaccount:team:deployer", now))
+	assert.False(t, limiter.allow("system:serviceaccount:team:deployer", now), "other service accounts are limited")
+}
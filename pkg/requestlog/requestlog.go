@@ -0,0 +1,53 @@
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Header carrying a request ID assigned by the caller, e.g. a proxy in front of the webhook.
+const Header = "X-Request-Id"
+
+// Log field holding the request ID.
+const Field = "request-id"
+
+// Request IDs given by callers are only accepted if they are reasonably short and printable.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// ID returns the caller's request ID if it is valid, or generates a new one.
+func ID(given string) string {
+	if validID.MatchString(given) {
+		return given
+	}
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// WithID returns a context carrying a logger that includes the request ID in every entry.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, log.WithField(Field, id))
+}
+
+// FromContext returns the request's logger, or the standard logger if the context carries none.
+func FromContext(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(contextKey{}).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// IDFromContext returns the request ID carried by the context, if any.
+func IDFromContext(ctx context.Context) string {
+	id, _ := FromContext(ctx).Data[Field].(string)
+	return id
+}
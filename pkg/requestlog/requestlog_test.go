@@ -0,0 +1,27 @@
+package requestlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestID(t *testing.T) {
+	assert.Equal(t, "abc-123", ID("abc-123"))
+
+	generated := ID("")
+	assert.Len(t, generated, 16)
+	assert.NotEqual(t, generated, ID(""))
+
+	assert.Len(t, ID("injected\nlog line"), 16)
+}
+
+func TestContextLogger(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, IDFromContext(ctx))
+
+	ctx = WithID(ctx, "abc-123")
+	assert.Equal(t, "abc-123", IDFromContext(ctx))
+	assert.Equal(t, "abc-123", FromContext(ctx).Data[Field])
+}
@@ -0,0 +1,551 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	contentType = "application/scim+json"
+)
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+}
+
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Active     bool     `json:"active"`
+	Meta       Meta     `json:"meta"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        Meta     `json:"meta"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	StartIndex   int           `json:"startIndex"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type PatchRequest struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+type Error struct {
+	Schemas []string `json:"schemas"`
+	Status  string   `json:"status"`
+	Detail  string   `json:"detail"`
+}
+
+var (
+	filterPattern       = regexp.MustCompile(`^(\w+) eq "([^"]*)"$`)
+	memberFilterPattern = regexp.MustCompile(`^members\[value eq "([^"]*)"\]$`)
+)
+
+// Store persists the users and groups of a server, as identity providers only push changes and never
+// resend unchanged users and groups.
+type Store interface {
+	// Load returns the data last saved, or nil if nothing has been saved yet.
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, data []byte) error
+}
+
+// Persisted users and groups, by ID.
+type state struct {
+	Users  map[string]*User  `json:"users"`
+	Groups map[string]*Group `json:"groups"`
+}
+
+// Server is a minimal SCIM 2.0 service provider accepting users and groups pushed from an identity provider.
+// Every group is a team, identified by its display name, and the group's external ID is the group identifier
+// found in Kubernetes user groups.
+//
+// State is loaded from the store before every request and every team list, and saved after every change, so that
+// replicas sharing a store converge. Changes pushed simultaneously to different replicas may overwrite each
+// other, so identity providers should reach a single replica.
+//
+// Server implements the team provider interface, and signals changes so that the team cache is updated immediately.
+type Server struct {
+	token   string
+	prefix  string
+	store   Store
+	mutex   sync.Mutex
+	loaded  bool
+	users   map[string]*User
+	groups  map[string]*Group
+	changes chan struct{}
+}
+
+// New returns a SCIM server mounted at the specified path prefix, e.g. /scim/v2.
+// Requests must carry the specified bearer token. If store is nil, state is kept in memory only.
+func New(prefix, token string, store Store) *Server {
+	return &Server{
+		token:   token,
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		store:   store,
+		loaded:  store == nil,
+		users:   make(map[string]*User),
+		groups:  make(map[string]*Group),
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// Replace the users and groups with those last saved to the store.
+func (s *Server) load(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	data, err := s.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	loaded := state{
+		Users:  make(map[string]*User),
+		Groups: make(map[string]*Group),
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &loaded)
+		if err != nil {
+			return fmt.Errorf("while decoding stored state: %s", err)
+		}
+	}
+	s.users, s.groups, s.loaded = loaded.Users, loaded.Groups, true
+	return nil
+}
+
+// Save the users and groups after a change, and signal the change. Unsaved changes are discarded when
+// state is loaded before the next request.
+func (s *Server) save(ctx context.Context) error {
+	if s.store != nil {
+		data, err := json.Marshal(state{Users: s.users, Groups: s.groups})
+		if err != nil {
+			return err
+		}
+		err = s.store.Save(ctx, data)
+		if err != nil {
+			return err
+		}
+	}
+	s.changed()
+	return nil
+}
+
+// Teams returns the teams of the stored groups. Fails until state has been loaded from the store,
+// so that the team cache is not emptied after a restart. If the store is unavailable later on,
+// the teams last loaded are returned.
+func (s *Server) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		if !s.loaded {
+			return nil, fmt.Errorf("while loading SCIM state: %s", err)
+		}
+		log.Warnf("scim: using teams last loaded, as loading state failed: %s", err)
+	}
+
+	teams := make(map[string]azure.Team)
+	for _, group := range s.groups {
+		team := azure.Team{
+			AzureUUID: group.ExternalID,
+			ID:        strings.ToLower(group.DisplayName),
+			Title:     group.DisplayName,
+		}
+		for _, member := range group.Members {
+			user, ok := s.users[member.Value]
+			if ok && user.Active {
+				team.Members = append(team.Members, user.UserName)
+			}
+		}
+		if !team.Valid() {
+			log.Debugf("scim: skipping group '%s' without external ID or members", group.DisplayName)
+			continue
+		}
+		teams[team.ID] = team
+	}
+
+	return teams, nil
+}
+
+func (s *Server) Changes() <-chan struct{} {
+	return s.changes
+}
+
+func (s *Server) changed() {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("scim: while sending response: %s", err)
+	}
+}
+
+func respondError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	respond(w, status, Error{
+		Schemas: []string{SchemaError},
+		Status:  fmt.Sprintf("%d", status),
+		Detail:  fmt.Sprintf(format, a...),
+	})
+}
+
+func listResponse(resources []interface{}) ListResponse {
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		ItemsPerPage: len(resources),
+		StartIndex:   1,
+		Resources:    resources,
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	expected := []byte("Bearer " + s.token)
+	return len(s.token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		respondError(w, http.StatusUnauthorized, "invalid bearer token")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, s.prefix), "/")
+	parts := strings.SplitN(path, "/", 2)
+	id := ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(r.Context()); err != nil {
+		respondError(w, http.StatusServiceUnavailable, "while loading state: %s", err)
+		return
+	}
+
+	switch parts[0] {
+	case "Users":
+		s.serveUsers(w, r, id)
+	case "Groups":
+		s.serveGroups(w, r, id)
+	default:
+		respondError(w, http.StatusNotFound, "unknown resource type '%s'", parts[0])
+	}
+}
+
+func (s *Server) serveUsers(w http.ResponseWriter, r *http.Request, id string) {
+	user, found := s.users[id]
+	if len(id) > 0 && !found {
+		respondError(w, http.StatusNotFound, "user '%s' not found", id)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(id) == 0:
+		resources := make([]interface{}, 0)
+		for _, user := range s.users {
+			if matchesFilter(r.URL.Query().Get("filter"), map[string]string{"userName": user.UserName, "externalId": user.ExternalID}) {
+				resources = append(resources, user)
+			}
+		}
+		respond(w, http.StatusOK, listResponse(resources))
+
+	case r.Method == http.MethodGet:
+		respond(w, http.StatusOK, user)
+
+	case r.Method == http.MethodPost && len(id) == 0, r.Method == http.MethodPut:
+		submitted := &User{}
+		if err := json.NewDecoder(r.Body).Decode(submitted); err != nil {
+			respondError(w, http.StatusBadRequest, "while decoding user: %s", err)
+			return
+		}
+		status := http.StatusOK
+		if len(id) == 0 {
+			id = newID()
+			status = http.StatusCreated
+		}
+		submitted.ID = id
+		submitted.Schemas = []string{SchemaUser}
+		submitted.Meta = Meta{ResourceType: "User"}
+		s.users[id] = submitted
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving user: %s", err)
+			return
+		}
+		log.Infof("scim: stored user '%s'", submitted.UserName)
+		respond(w, status, submitted)
+
+	case r.Method == http.MethodPatch:
+		patch := &PatchRequest{}
+		if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+			respondError(w, http.StatusBadRequest, "while decoding patch: %s", err)
+			return
+		}
+		// Operations are applied to a copy, so that a failing operation leaves the user unchanged.
+		patched := *user
+		for _, op := range patch.Operations {
+			if err := patchUser(&patched, op); err != nil {
+				respondError(w, http.StatusBadRequest, "%s", err)
+				return
+			}
+		}
+		s.users[id] = &patched
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving user: %s", err)
+			return
+		}
+		respond(w, http.StatusOK, &patched)
+
+	case r.Method == http.MethodDelete:
+		delete(s.users, id)
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving users: %s", err)
+			return
+		}
+		log.Infof("scim: deleted user '%s'", user.UserName)
+		respond(w, http.StatusNoContent, nil)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func (s *Server) serveGroups(w http.ResponseWriter, r *http.Request, id string) {
+	group, found := s.groups[id]
+	if len(id) > 0 && !found {
+		respondError(w, http.StatusNotFound, "group '%s' not found", id)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(id) == 0:
+		resources := make([]interface{}, 0)
+		for _, group := range s.groups {
+			if matchesFilter(r.URL.Query().Get("filter"), map[string]string{"displayName": group.DisplayName, "externalId": group.ExternalID}) {
+				resources = append(resources, group)
+			}
+		}
+		respond(w, http.StatusOK, listResponse(resources))
+
+	case r.Method == http.MethodGet:
+		respond(w, http.StatusOK, group)
+
+	case r.Method == http.MethodPost && len(id) == 0, r.Method == http.MethodPut:
+		submitted := &Group{}
+		if err := json.NewDecoder(r.Body).Decode(submitted); err != nil {
+			respondError(w, http.StatusBadRequest, "while decoding group: %s", err)
+			return
+		}
+		status := http.StatusOK
+		if len(id) == 0 {
+			id = newID()
+			status = http.StatusCreated
+		}
+		submitted.ID = id
+		submitted.Schemas = []string{SchemaGroup}
+		submitted.Meta = Meta{ResourceType: "Group"}
+		s.groups[id] = submitted
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving group: %s", err)
+			return
+		}
+		log.Infof("scim: stored group '%s' with %d members", submitted.DisplayName, len(submitted.Members))
+		respond(w, status, submitted)
+
+	case r.Method == http.MethodPatch:
+		patch := &PatchRequest{}
+		if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+			respondError(w, http.StatusBadRequest, "while decoding patch: %s", err)
+			return
+		}
+		// Operations are applied to a copy, so that a failing operation leaves the group unchanged.
+		patched := *group
+		patched.Members = append([]Member(nil), group.Members...)
+		for _, op := range patch.Operations {
+			if err := patchGroup(&patched, op); err != nil {
+				respondError(w, http.StatusBadRequest, "%s", err)
+				return
+			}
+		}
+		s.groups[id] = &patched
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving group: %s", err)
+			return
+		}
+		log.Infof("scim: patched group '%s'", patched.DisplayName)
+		respond(w, http.StatusOK, &patched)
+
+	case r.Method == http.MethodDelete:
+		delete(s.groups, id)
+		if err := s.save(r.Context()); err != nil {
+			respondError(w, http.StatusInternalServerError, "while saving groups: %s", err)
+			return
+		}
+		log.Infof("scim: deleted group '%s'", group.DisplayName)
+		respond(w, http.StatusNoContent, nil)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// Only the equality filters sent by identity providers during provisioning are supported.
+func matchesFilter(filter string, attributes map[string]string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	match := filterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return false
+	}
+	return attributes[match[1]] == match[2]
+}
+
+func patchUser(user *User, op Operation) error {
+	values := make(map[string]json.RawMessage)
+	if len(op.Path) > 0 {
+		values[op.Path] = op.Value
+	} else if err := json.Unmarshal(op.Value, &values); err != nil {
+		return fmt.Errorf("while decoding patch value: %s", err)
+	}
+
+	for path, value := range values {
+		var err error
+		switch path {
+		case "active":
+			err = json.Unmarshal(value, &user.Active)
+		case "userName":
+			err = json.Unmarshal(value, &user.UserName)
+		case "externalId":
+			err = json.Unmarshal(value, &user.ExternalID)
+		default:
+			log.Debugf("scim: ignoring patch of user attribute '%s'", path)
+		}
+		if err != nil {
+			return fmt.Errorf("while patching '%s': %s", path, err)
+		}
+	}
+	return nil
+}
+
+func patchGroup(group *Group, op Operation) error {
+	if match := memberFilterPattern.FindStringSubmatch(op.Path); match != nil && strings.EqualFold(op.Op, "remove") {
+		group.Members = removeMembers(group.Members, []Member{{Value: match[1]}})
+		return nil
+	}
+
+	values := make(map[string]json.RawMessage)
+	if len(op.Path) > 0 {
+		values[op.Path] = op.Value
+	} else if err := json.Unmarshal(op.Value, &values); err != nil {
+		return fmt.Errorf("while decoding patch value: %s", err)
+	}
+
+	for path, value := range values {
+		var err error
+		switch path {
+		case "displayName":
+			err = json.Unmarshal(value, &group.DisplayName)
+		case "externalId":
+			err = json.Unmarshal(value, &group.ExternalID)
+		case "members":
+			members := make([]Member, 0)
+			if len(value) > 0 {
+				err = json.Unmarshal(value, &members)
+			}
+			switch strings.ToLower(op.Op) {
+			case "add":
+				group.Members = append(removeMembers(group.Members, members), members...)
+			case "remove":
+				if len(members) == 0 {
+					group.Members = nil
+				} else {
+					group.Members = removeMembers(group.Members, members)
+				}
+			case "replace":
+				group.Members = members
+			}
+		default:
+			log.Debugf("scim: ignoring patch of group attribute '%s'", path)
+		}
+		if err != nil {
+			return fmt.Errorf("while patching '%s': %s", path, err)
+		}
+	}
+	return nil
+}
+
+func removeMembers(members, remove []Member) []Member {
+	result := make([]Member, 0, len(members))
+	for _, member := range members {
+		found := false
+		for _, r := range remove {
+			if member.Value == r.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, member)
+		}
+	}
+	return result
+}
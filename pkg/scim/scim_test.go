@@ -0,0 +1,135 @@
+package scim_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nais/tobac/pkg/scim"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(server *scim.Server, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func TestProvisioning(t *testing.T) {
+	server := scim.New("/scim/v2/", "secret", nil)
+
+	w := request(server, http.MethodPost, "/scim/v2/Users", `{"userName":"user@example.com","active":true}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	user := &scim.User{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), user))
+
+	w = request(server, http.MethodPost, "/scim/v2/Groups", `{"displayName":"Team-A","externalId":"uuid"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	group := &scim.Group{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), group))
+
+	w = request(server, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[{"op":"Add","path":"members","value":[{"value":"`+user.ID+`"}]}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	select {
+	case <-server.Changes():
+	default:
+		t.Error("expected change notification")
+	}
+
+	teams, err := server.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid", teams["team-a"].AzureUUID)
+	assert.Equal(t, []string{"user@example.com"}, teams["team-a"].Members)
+
+	w = request(server, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[{"op":"Remove","path":"members[value eq \"`+user.ID+`\"]"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	teams, err = server.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, teams["team-a"].Members)
+
+	w = request(server, http.MethodGet, `/scim/v2/Users?filter=userName+eq+"user@example.com"`, "")
+	list := &scim.ListResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), list))
+	assert.Equal(t, 1, list.TotalResults)
+}
+
+func TestUnauthorized(t *testing.T) {
+	server := scim.New("/scim/v2/", "secret", nil)
+	r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPatchIsAtomic(t *testing.T) {
+	server := scim.New("/scim/v2/", "secret", nil)
+
+	w := request(server, http.MethodPost, "/scim/v2/Groups", `{"displayName":"Team-A","externalId":"uuid"}`)
+	group := &scim.Group{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), group))
+	<-server.Changes()
+
+	w = request(server, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[`+
+		`{"op":"Replace","path":"displayName","value":"Team-B"},{"op":"Replace","path":"externalId","value":42}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	select {
+	case <-server.Changes():
+		t.Error("unexpected change notification")
+	default:
+	}
+
+	teams, err := server.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, teams, "team-a", "earlier operations of a failed patch are not applied")
+	assert.Equal(t, "uuid", teams["team-a"].AzureUUID)
+}
+
+type memoryStore struct {
+	data []byte
+	err  error
+}
+
+func (m *memoryStore) Load(ctx context.Context) ([]byte, error) {
+	return m.data, m.err
+}
+
+func (m *memoryStore) Save(ctx context.Context, data []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.data = data
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	store := &memoryStore{}
+	server := scim.New("/scim/v2/", "secret", store)
+
+	w := request(server, http.MethodPost, "/scim/v2/Groups", `{"displayName":"Team-A","externalId":"uuid"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	restarted := scim.New("/scim/v2/", "secret", store)
+	teams, err := restarted.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid", teams["team-a"].AzureUUID, "state is loaded from the store")
+
+	store.err = fmt.Errorf("unavailable")
+	_, err = scim.New("/scim/v2/", "secret", store).Teams(context.Background())
+	assert.Error(t, err, "teams are not returned until state has been loaded")
+
+	teams, err = restarted.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, teams, "team-a", "the teams last loaded are returned when the store is unavailable")
+
+	w = request(restarted, http.MethodPost, "/scim/v2/Groups", `{"displayName":"Team-B","externalId":"uuid-b"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
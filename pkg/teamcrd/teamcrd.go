@@ -0,0 +1,60 @@
+package teamcrd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/kubeclient"
+	"github.com/nais/tobac/pkg/teamfile"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+)
+
+// Provider reads teams from cluster-scoped Team resources, whose spec uses the fields of the file provider, e.g.
+//
+//	apiVersion: tobac.nais.io/v1alpha1
+//	kind: Team
+//	metadata:
+//	  name: team-a
+//	spec:
+//	  title: Team A
+//	  groups:
+//	    - 00000000-0000-0000-0000-000000000000
+//
+// The team ID defaults to the resource name.
+type Provider struct {
+	client dynamic.Interface
+}
+
+func New(client dynamic.Interface) *Provider {
+	return &Provider{client: client}
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	items, err := kubeclient.Teams(ctx, p.client)
+	if err != nil {
+		return nil, fmt.Errorf("while listing Team resources: %s", err)
+	}
+
+	fileTeams := make([]teamfile.Team, 0, len(items))
+	for _, item := range items {
+		var team teamfile.Team
+		spec, _ := item.Object["spec"].(map[string]interface{})
+		data, err := json.Marshal(spec)
+		if err == nil {
+			err = json.Unmarshal(data, &team)
+		}
+		if err != nil {
+			log.Errorf("teamcrd: invalid team '%s': %s", item.GetName(), err)
+			continue
+		}
+		if len(team.ID) == 0 {
+			team.ID = item.GetName()
+		}
+		fileTeams = append(fileTeams, team)
+	}
+
+	return teamfile.Convert(fileTeams), nil
+}
@@ -0,0 +1,54 @@
+package teamcrd_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/tobac/pkg/kubeclient"
+	"github.com/nais/tobac/pkg/teamcrd"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+const teamList = `{"apiVersion":"tobac.nais.io/v1alpha1","kind":"TeamList","metadata":{"resourceVersion":"1"},"items":[
+  {"apiVersion":"tobac.nais.io/v1alpha1","kind":"Team","metadata":{"name":"team-a"},"spec":{"title":"Team A","members":["user@example.com"]}},
+  {"apiVersion":"tobac.nais.io/v1alpha1","kind":"Team","metadata":{"name":"renamed"},"spec":{"id":"Team-B","groups":["00000000-0000-0000-0000-000000000000"]}},
+  {"apiVersion":"tobac.nais.io/v1alpha1","kind":"Team","metadata":{"name":"no-members"},"spec":{}}
+]}`
+
+func TestTeams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/tobac.nais.io/v1alpha1/teams", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, teamList)
+	}))
+	defer server.Close()
+
+	client, err := kubeclient.New(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	result, err := teamcrd.New(client).Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "Team A", result["team-a"].Title)
+	assert.Equal(t, []string{"user@example.com"}, result["team-a"].Members)
+	assert.Equal(t, []string{"00000000-0000-0000-0000-000000000000"}, result["team-b"].Groups, "the team ID overrides the resource name")
+}
+
+func TestTeamsUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`)
+	}))
+	defer server.Close()
+
+	client, err := kubeclient.New(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	_, err = teamcrd.New(client).Teams(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package teamfile
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// Team is the file representation of a team.
+type Team struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Contact     string   `json:"contact,omitempty"`
+	AzureUUID   string   `json:"azureUUID,omitempty"` // group whose members belong to the team, kept to track renames
+	Groups      []string `json:"groups"`
+	Members     []string `json:"members"`
+	Aliases     []string `json:"aliases"`
+	DeniedKinds []string `json:"deniedKinds"`
+}
+
+// Provider reads teams from a YAML or JSON file containing a list of teams, e.g.
+//
+//	# teams.yaml
+//	- id: team-a
+//	  title: Team A
+//	  groups:
+//	    - 00000000-0000-0000-0000-000000000000
+//	  members:
+//	    - user@example.com
+type Provider struct {
+	path     string
+	interval time.Duration
+	changes  chan struct{}
+}
+
+// New returns a provider reading teams from the specified file.
+// The file is checked for changes at the specified interval, or never if the interval is zero.
+func New(path string, interval time.Duration) *Provider {
+	return &Provider{
+		path:     path,
+		interval: interval,
+	}
+}
+
+// Parse decodes a YAML or JSON list of teams.
+func Parse(data []byte) (map[string]azure.Team, error) {
+	fileTeams := make([]Team, 0)
+	err := yaml.Unmarshal(data, &fileTeams)
+	if err != nil {
+		return nil, err
+	}
+
+	return Convert(fileTeams), nil
+}
+
+// Convert returns the valid teams of a list, by lowercased team ID. Invalid teams are logged and skipped.
+func Convert(fileTeams []Team) map[string]azure.Team {
+	teams := make(map[string]azure.Team)
+	for _, fileTeam := range fileTeams {
+		team := azure.Team{
+			ID:          strings.ToLower(fileTeam.ID),
+			Title:       fileTeam.Title,
+			Description: fileTeam.Description,
+			Contact:     fileTeam.Contact,
+			AzureUUID:   fileTeam.AzureUUID,
+			Groups:      fileTeam.Groups,
+			Members:     fileTeam.Members,
+			Aliases:     fileTeam.Aliases,
+			DeniedKinds: fileTeam.DeniedKinds,
+		}
+		if !team.Valid() {
+			log.Errorf("teamfile: invalid team '%s'", fileTeam.ID)
+			continue
+		}
+		teams[team.ID] = team
+	}
+
+	return teams
+}
+
+// Marshal encodes teams in the format read by Parse, sorted by team ID.
+func Marshal(teams map[string]azure.Team) ([]byte, error) {
+	fileTeams := make([]Team, 0, len(teams))
+	for _, team := range teams {
+		fileTeams = append(fileTeams, Team{
+			ID:          team.ID,
+			Title:       team.Title,
+			Description: team.Description,
+			Contact:     team.Contact,
+			AzureUUID:   team.AzureUUID,
+			Groups:      team.Groups,
+			Members:     team.Members,
+			Aliases:     team.Aliases,
+			DeniedKinds: team.DeniedKinds,
+		})
+	}
+	sort.Slice(fileTeams, func(i, j int) bool {
+		return fileTeams[i].ID < fileTeams[j].ID
+	})
+
+	return yaml.Marshal(fileTeams)
+}
+
+// WriteSnapshot writes teams to a file that can be read back by the file provider.
+// The file is replaced atomically, so that readers never see a partially written file.
+func WriteSnapshot(path string, teams map[string]azure.Team) error {
+	data, err := Marshal(teams)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	teams, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", p.path, err)
+	}
+
+	return teams, nil
+}
+
+// Changes returns a channel signaled whenever the file is modified.
+// Returns nil, a channel that is never signaled, if the file is not checked for changes.
+func (p *Provider) Changes() <-chan struct{} {
+	if p.interval <= 0 {
+		return nil
+	}
+	if p.changes == nil {
+		p.changes = make(chan struct{}, 1)
+		go p.watch()
+	}
+	return p.changes
+}
+
+// Poll the file for changes. Polling is used instead of filesystem notifications,
+// because mounted ConfigMaps are updated by swapping symbolic links.
+func (p *Provider) watch() {
+	var modified time.Time
+	if info, err := os.Stat(p.path); err == nil {
+		modified = info.ModTime()
+	}
+
+	for range time.Tick(p.interval) {
+		info, err := os.Stat(p.path)
+		if err != nil {
+			log.Errorf("teamfile: %s", err)
+			continue
+		}
+		if info.ModTime().Equal(modified) {
+			continue
+		}
+		modified = info.ModTime()
+		log.Infof("teamfile: '%s' has changed", p.path)
+		select {
+		case p.changes <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package teamfile_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/teamfile"
+	"github.com/stretchr/testify/assert"
+)
+
+const teams = `
+- id: Team-A
+  title: Team A
+  groups:
+    - 00000000-0000-0000-0000-000000000000
+  members:
+    - user@example.com
+- id: no-members
+`
+
+func TestTeams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teamfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "teams.yaml")
+	err = ioutil.WriteFile(path, []byte(teams), 0600)
+	assert.NoError(t, err)
+
+	provider := teamfile.New(path, 0)
+	assert.Nil(t, provider.Changes(), "the file is not checked for changes")
+	result, err := provider.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+
+	team := result["team-a"]
+	assert.True(t, team.Valid())
+	assert.Equal(t, "Team A", team.Title)
+	assert.Equal(t, []string{"00000000-0000-0000-0000-000000000000"}, team.Groups)
+	assert.Equal(t, []string{"user@example.com"}, team.Members)
+}
+
+func TestMarshal(t *testing.T) {
+	original := map[string]azure.Team{
+		"team-a": {ID: "team-a", Title: "Team A", AzureUUID: "uuid-a", Aliases: []string{"old-a"}, Members: []string{"user@example.com"}},
+	}
+	data, err := teamfile.Marshal(original)
+	assert.NoError(t, err)
+
+	parsed, err := teamfile.Parse(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid-a", parsed["team-a"].AzureUUID, "the group UUID is kept, so that renames can be tracked")
+	assert.Equal(t, []string{"old-a"}, parsed["team-a"].Aliases)
+	assert.Empty(t, parsed["team-a"].Groups)
+}
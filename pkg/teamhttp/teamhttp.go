@@ -0,0 +1,63 @@
+package teamhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/teamfile"
+)
+
+// Provider retrieves teams from a HTTP endpoint returning a JSON list of teams,
+// using the same format as the file provider.
+type Provider struct {
+	client        *http.Client
+	url           string
+	authorization string
+}
+
+// New returns a provider retrieving teams from the specified URL.
+// If authorization is non-empty, it is sent as the Authorization header.
+func New(client *http.Client, url, authorization string) *Provider {
+	return &Provider{
+		client:        client,
+		url:           url,
+		authorization: authorization,
+	}
+}
+
+func (p *Provider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if len(p.authorization) > 0 {
+		req.Header.Set("Authorization", p.authorization)
+	}
+
+	response, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", response.Status, string(body))
+	}
+
+	teams, err := teamfile.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing team list from %s: %s", p.url, err)
+	}
+
+	return teams, nil
+}
@@ -0,0 +1,47 @@
+package teamhttp_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/tobac/pkg/teamhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+const teams = `[
+  {"id": "Team-A", "title": "Team A", "groups": ["00000000-0000-0000-0000-000000000000"], "members": ["user@example.com"]},
+  {"id": "no-members"}
+]`
+
+func TestTeams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(teams))
+	}))
+	defer server.Close()
+
+	result, err := teamhttp.New(server.Client(), server.URL, "Bearer secret").Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, "Team A", result["team-a"].Title)
+	assert.Equal(t, []string{"user@example.com"}, result["team-a"].Members)
+
+	_, err = teamhttp.New(server.Client(), server.URL, "").Teams(context.Background())
+	assert.Error(t, err)
+}
+
+func TestTeamsInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "not a list"`))
+	}))
+	defer server.Close()
+
+	_, err := teamhttp.New(server.Client(), server.URL, "").Teams(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package teams
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nais/tobac/pkg/azure"
+)
+
+const (
+	// MergeUnion combines teams with the same ID from all providers, granting access to members from any of them.
+	MergeUnion = "union"
+	// MergePriority uses a team from the first provider that knows about it, ignoring the rest.
+	MergePriority = "priority"
+)
+
+// Composite merges teams from several providers, in order of priority.
+// A sync fails if any of the providers fail, so that teams are never partially removed from the cache.
+type Composite struct {
+	providers []Provider
+	strategy  string
+}
+
+func NewComposite(strategy string, providers ...Provider) (*Composite, error) {
+	switch strategy {
+	case MergeUnion, MergePriority:
+	default:
+		return nil, fmt.Errorf("merge strategy '%s' is not recognized", strategy)
+	}
+	return &Composite{
+		providers: providers,
+		strategy:  strategy,
+	}, nil
+}
+
+func (c *Composite) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	merged := make(map[string]azure.Team)
+
+	for i, provider := range c.providers {
+		teams, err := provider.Teams(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("provider %d: %s", i+1, err)
+		}
+		for id, team := range teams {
+			existing, found := merged[id]
+			switch {
+			case !found:
+				merged[id] = team
+			case c.strategy == MergeUnion:
+				merged[id] = union(existing, team)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// Members lists team members from all providers able to list them.
+func (c *Composite) Members(ctx context.Context, team azure.Team) ([]string, error) {
+	members := make([]string, 0)
+	for i, provider := range c.providers {
+		lister, ok := provider.(MemberLister)
+		if !ok {
+			continue
+		}
+		m, err := lister.Members(ctx, team)
+		if err != nil {
+			return nil, fmt.Errorf("provider %d: %s", i+1, err)
+		}
+		members = appendUnique(members, m...)
+	}
+	return members, nil
+}
+
+// Team looks up a team in all providers able to, merging the results like Teams.
+func (c *Composite) Team(ctx context.Context, id string) (azure.Team, error) {
+	var merged azure.Team
+	for i, provider := range c.providers {
+		lookup, ok := provider.(TeamLookup)
+		if !ok {
+			continue
+		}
+		team, err := lookup.Team(ctx, id)
+		if err != nil {
+			return azure.Team{}, fmt.Errorf("provider %d: %s", i+1, err)
+		}
+		switch {
+		case !team.Valid():
+		case !merged.Valid():
+			merged = team
+		case c.strategy == MergeUnion:
+			merged = union(merged, team)
+		}
+	}
+	return merged, nil
+}
+
+// Changes forwards change notifications from all providers able to signal them.
+func (c *Composite) Changes() <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	for _, provider := range c.providers {
+		notifier, ok := provider.(Notifier)
+		if !ok {
+			continue
+		}
+		go func(source <-chan struct{}) {
+			for range source {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}(notifier.Changes())
+	}
+	return changes
+}
+
+func appendUnique(slice []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, s := range slice {
+			if s == value {
+				found = true
+				break
+			}
+		}
+		if !found && len(value) > 0 {
+			slice = append(slice, value)
+		}
+	}
+	return slice
+}
+
+// Merge two teams with the same ID. Values from the first team take precedence.
+func union(a, b azure.Team) azure.Team {
+	if len(a.AzureUUID) == 0 {
+		a.AzureUUID = b.AzureUUID
+	} else if a.AzureUUID != b.AzureUUID {
+		a.Groups = appendUnique(a.Groups, b.AzureUUID)
+	}
+	if len(a.Title) == 0 {
+		a.Title = b.Title
+	}
+	if len(a.Description) == 0 {
+		a.Description = b.Description
+	}
+	if len(a.Contact) == 0 {
+		a.Contact = b.Contact
+	}
+	a.Groups = appendUnique(a.Groups, b.Groups...)
+	a.Members = appendUnique(a.Members, b.Members...)
+	a.Aliases = appendUnique(a.Aliases, b.Aliases...)
+	a.DeniedKinds = appendUnique(a.DeniedKinds, b.DeniedKinds...)
+	return a
+}
@@ -0,0 +1,77 @@
+package teams_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/teams"
+	"github.com/stretchr/testify/assert"
+)
+
+func staticProvider(teamList ...azure.Team) teams.Provider {
+	return teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		m := make(map[string]azure.Team)
+		for _, team := range teamList {
+			m[team.ID] = team
+		}
+		return m, nil
+	})
+}
+
+var primary = staticProvider(
+	azure.Team{ID: "foo", AzureUUID: "foo-uuid", Members: []string{"alice"}},
+)
+
+var secondary = staticProvider(
+	azure.Team{ID: "foo", AzureUUID: "legacy-foo-uuid", Title: "Foo", Members: []string{"alice", "bob"}},
+	azure.Team{ID: "bar", AzureUUID: "bar-uuid"},
+)
+
+func TestCompositePriority(t *testing.T) {
+	composite, err := teams.NewComposite(teams.MergePriority, primary, secondary)
+	assert.NoError(t, err)
+
+	result, err := composite.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, azure.Team{ID: "foo", AzureUUID: "foo-uuid", Members: []string{"alice"}}, result["foo"])
+}
+
+func TestCompositeUnion(t *testing.T) {
+	composite, err := teams.NewComposite(teams.MergeUnion, primary, secondary)
+	assert.NoError(t, err)
+
+	result, err := composite.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, azure.Team{
+		ID:        "foo",
+		AzureUUID: "foo-uuid",
+		Title:     "Foo",
+		Groups:    []string{"legacy-foo-uuid"},
+		Members:   []string{"alice", "bob"},
+	}, result["foo"])
+}
+
+type listingProvider struct {
+	teams.Provider
+	members []string
+}
+
+func (p listingProvider) Members(ctx context.Context, team azure.Team) ([]string, error) {
+	return p.members, nil
+}
+
+func TestMembers(t *testing.T) {
+	composite, err := teams.NewComposite(teams.MergeUnion, primary, listingProvider{secondary, []string{"alice"}}, listingProvider{secondary, []string{"alice", "bob"}})
+	assert.NoError(t, err)
+
+	members, err := teams.Members(context.Background(), composite, azure.Team{ID: "bar", AzureUUID: "bar-uuid"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, members)
+
+	members, err = teams.Members(context.Background(), composite, azure.Team{ID: "foo", Members: []string{"carol"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"carol"}, members, "cached members are preferred")
+}
@@ -0,0 +1,108 @@
+package teams
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nais/tobac/pkg/azure"
+)
+
+// Export is a point in time copy of the team cache, for compliance audits.
+type Export struct {
+	Generated time.Time      `json:"generated"`
+	Updated   time.Time      `json:"updated"`
+	Source    string         `json:"source"`
+	Teams     []ExportedTeam `json:"teams"`
+	// Hex encoded SHA-256 of the JSON encoded team list, so that auditors can detect modified exports.
+	Hash string `json:"sha256"`
+}
+
+type ExportedTeam struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	AzureUUID string     `json:"azureUUID"`
+	Aliases   []string   `json:"aliases"`
+	Groups    []string   `json:"groups"`
+	Members   []string   `json:"members"`
+	Deleted   *time.Time `json:"deleted,omitempty"`
+}
+
+// NewExport exports all cached teams, sorted by ID.
+func NewExport() (*Export, error) {
+	c := load()
+	export := &Export{
+		Generated: time.Now().UTC(),
+		Updated:   c.updated.UTC(),
+		Source:    c.source,
+		Teams:     make([]ExportedTeam, 0, len(c.teams)),
+	}
+
+	for _, team := range c.teams {
+		export.Teams = append(export.Teams, Exported(team))
+	}
+	sort.Slice(export.Teams, func(i, j int) bool {
+		return export.Teams[i].ID < export.Teams[j].ID
+	})
+
+	data, err := json.Marshal(export.Teams)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	export.Hash = hex.EncodeToString(sum[:])
+
+	return export, nil
+}
+
+// Exported returns the export representation of a team.
+func Exported(team azure.Team) ExportedTeam {
+	exported := ExportedTeam{
+		ID:        team.ID,
+		Title:     team.Title,
+		AzureUUID: team.AzureUUID,
+		Aliases:   sorted(team.Aliases),
+		Groups:    sorted(team.Groups),
+		Members:   sorted(team.Members),
+	}
+	if !team.Deleted.IsZero() {
+		deleted := team.Deleted.UTC()
+		exported.Deleted = &deleted
+	}
+	return exported
+}
+
+// Return a sorted copy, never nil.
+func sorted(values []string) []string {
+	s := append(make([]string, 0, len(values)), values...)
+	sort.Strings(s)
+	return s
+}
+
+// WriteCSV writes one team per row. List values are separated by spaces.
+func (e *Export) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "title", "azure_uuid", "aliases", "groups", "members", "deleted"})
+	for _, team := range e.Teams {
+		deleted := ""
+		if team.Deleted != nil {
+			deleted = team.Deleted.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			team.ID,
+			team.Title,
+			team.AzureUUID,
+			strings.Join(team.Aliases, " "),
+			strings.Join(team.Groups, " "),
+			strings.Join(team.Members, " "),
+			deleted,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
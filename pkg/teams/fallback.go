@@ -0,0 +1,109 @@
+package teams
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Fallback retrieves teams from its primary provider. If the primary provider fails a number of
+// consecutive times, the secondary providers are tried in order until one of them succeeds.
+// The primary provider is always tried first, so that it takes over again as soon as it recovers.
+type Fallback struct {
+	names     []string
+	providers []Provider
+	threshold int
+	failures  int
+	active    int
+}
+
+// NewFallback returns a fallback chain. Names and providers must be specified in order of priority.
+func NewFallback(threshold int, names []string, providers []Provider) (*Fallback, error) {
+	if len(names) != len(providers) || len(providers) == 0 {
+		return nil, fmt.Errorf("every fallback provider must be named")
+	}
+	fallback := &Fallback{
+		names:     names,
+		providers: providers,
+		threshold: threshold,
+	}
+	fallback.activate(0)
+	return fallback, nil
+}
+
+func (f *Fallback) activate(active int) {
+	f.active = active
+	for i, name := range f.names {
+		value := 0.0
+		if i == active {
+			value = 1.0
+		}
+		metrics.TeamProviderActive.WithLabelValues(name).Set(value)
+	}
+}
+
+func (f *Fallback) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	teams, err := f.providers[0].Teams(ctx)
+	if err == nil {
+		if f.failures >= f.threshold {
+			log.Infof("Team provider '%s' has recovered", f.names[0])
+		}
+		f.failures = 0
+		f.activate(0)
+		return teams, nil
+	}
+
+	f.failures++
+	if f.failures < f.threshold {
+		return nil, fmt.Errorf("%s (%d of %d failures before falling back)", err, f.failures, f.threshold)
+	}
+
+	log.Errorf("Team provider '%s' has failed %d consecutive times: %s", f.names[0], f.failures, err)
+
+	for i := 1; i < len(f.providers); i++ {
+		teams, err = f.providers[i].Teams(ctx)
+		if err != nil {
+			log.Errorf("Fallback team provider '%s' failed: %s", f.names[i], err)
+			continue
+		}
+		log.Warnf("Using teams from fallback team provider '%s'", f.names[i])
+		f.activate(i)
+		return teams, nil
+	}
+
+	return nil, fmt.Errorf("all team providers failed")
+}
+
+// Source returns the name of the provider that returned the latest team list.
+func (f *Fallback) Source() string {
+	return f.names[f.active]
+}
+
+// Members lists team members from the first provider able to list them.
+func (f *Fallback) Members(ctx context.Context, team azure.Team) ([]string, error) {
+	for _, provider := range f.providers {
+		if lister, ok := provider.(MemberLister); ok {
+			return lister.Members(ctx, team)
+		}
+	}
+	return nil, nil
+}
+
+// Team looks up a team in the first provider able to.
+func (f *Fallback) Team(ctx context.Context, id string) (azure.Team, error) {
+	for _, provider := range f.providers {
+		if lookup, ok := provider.(TeamLookup); ok {
+			return lookup.Team(ctx, id)
+		}
+	}
+	return azure.Team{}, nil
+}
+
+// Changes forwards change notifications from all providers able to signal them.
+func (f *Fallback) Changes() <-chan struct{} {
+	composite := &Composite{providers: f.providers}
+	return composite.Changes()
+}
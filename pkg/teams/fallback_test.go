@@ -0,0 +1,40 @@
+package teams_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/teams"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyProvider struct {
+	fail bool
+}
+
+func (p *flakyProvider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	if p.fail {
+		return nil, fmt.Errorf("unavailable")
+	}
+	return primary.Teams(ctx)
+}
+
+func TestFallback(t *testing.T) {
+	flaky := &flakyProvider{fail: true}
+	fallback, err := teams.NewFallback(2, []string{"flaky", "secondary"}, []teams.Provider{flaky, secondary})
+	assert.NoError(t, err)
+
+	_, err = fallback.Teams(context.Background())
+	assert.Error(t, err)
+
+	result, err := fallback.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	flaky.fail = false
+	result, err = fallback.Teams(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+}
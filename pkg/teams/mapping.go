@@ -0,0 +1,73 @@
+package teams
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+func loadListMap(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := make(map[string][]string)
+	err = yaml.Unmarshal(data, &mapping)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+
+	return mapping, nil
+}
+
+// LoadGroupMapping reads a YAML or JSON file mapping user groups to lists of team IDs, e.g.
+//
+//	legacy-group-uuid:
+//	  - team-a
+//	  - team-b
+func LoadGroupMapping(path string) (map[string][]string, error) {
+	mapping, err := loadListMap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for group, teamIDs := range mapping {
+		for i := range teamIDs {
+			teamIDs[i] = strings.ToLower(teamIDs[i])
+		}
+		mapping[group] = teamIDs
+	}
+
+	return mapping, nil
+}
+
+// LoadDeniedKinds reads a YAML or JSON file mapping team IDs to lists of resource kinds
+// the team's members may not manage, e.g.
+//
+//	team-a:
+//	  - ClusterRole
+//	  - CustomResourceDefinition
+func LoadDeniedKinds(path string) (map[string][]string, error) {
+	mapping, err := loadListMap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deniedKinds := make(map[string][]string)
+	for teamID, kinds := range mapping {
+		deniedKinds[strings.ToLower(teamID)] = kinds
+	}
+
+	return deniedKinds, nil
+}
+
+// LoadAliases reads a YAML or JSON file mapping team IDs to lists of former team names, e.g.
+//
+//	team-a:
+//	  - old-team-a
+func LoadAliases(path string) (map[string][]string, error) {
+	return loadListMap(path)
+}
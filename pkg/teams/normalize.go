@@ -0,0 +1,49 @@
+package teams
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// NormalizeLowercase matches team identifiers regardless of case.
+	NormalizeLowercase = "lowercase"
+	// NormalizeTrim ignores leading and trailing whitespace in team identifiers.
+	NormalizeTrim = "trim"
+	// NormalizeFold applies Unicode compatibility normalization and case folding,
+	// so that e.g. full-width characters match their ASCII equivalents.
+	NormalizeFold = "fold"
+)
+
+var normalizers = map[string]func(string) string{
+	NormalizeLowercase: strings.ToLower,
+	NormalizeTrim:      strings.TrimSpace,
+	NormalizeFold: func(s string) string {
+		return cases.Fold().String(norm.NFKC.String(s))
+	},
+}
+
+var normalization = []string{NormalizeLowercase}
+
+// SetNormalization configures how team identifiers are normalized before lookup.
+// Must be called before teams are synchronized.
+func SetNormalization(steps []string) error {
+	for _, step := range steps {
+		if _, ok := normalizers[step]; !ok {
+			return fmt.Errorf("team identifier normalization '%s' is not recognized", step)
+		}
+	}
+	normalization = steps
+	return nil
+}
+
+// Normalize returns the form of a team identifier used for lookups.
+func Normalize(id string) string {
+	for _, step := range normalization {
+		id = normalizers[step](id)
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+package teams
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nais/tobac/pkg/azure"
+)
+
+// Provider retrieves the canonical list of teams from a backend, keyed by team ID.
+type Provider interface {
+	Teams(ctx context.Context) (map[string]azure.Team, error)
+}
+
+// Notifier is implemented by team providers that can signal changes to their teams between sync intervals.
+type Notifier interface {
+	Changes() <-chan struct{}
+}
+
+// Sourcer is implemented by team providers delegating to one of several providers, naming the one last used.
+type Sourcer interface {
+	Source() string
+}
+
+// MemberLister is implemented by team providers that can list the members of a team on demand,
+// for teams whose members are not part of the synchronized team data.
+type MemberLister interface {
+	Members(ctx context.Context, team azure.Team) ([]string, error)
+}
+
+// TeamLookup is implemented by team providers that can retrieve a single team on demand.
+// An invalid team is returned if the team does not exist.
+type TeamLookup interface {
+	Team(ctx context.Context, id string) (azure.Team, error)
+}
+
+// ProviderFunc allows the use of ordinary functions as team providers.
+type ProviderFunc func(ctx context.Context) (map[string]azure.Team, error)
+
+func (f ProviderFunc) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	return f(ctx)
+}
+
+// Factory creates a team provider. Factories are called after configuration has been parsed.
+type Factory func() (Provider, error)
+
+var registry = make(map[string]Factory)
+
+// Register makes a team provider available by name.
+func Register(name string, factory Factory) {
+	registry[name] = factory
+}
+
+// Providers returns the names of all registered team providers.
+func Providers() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider instantiates the named team provider.
+func NewProvider(name string) (Provider, error) {
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("team provider '%s' is not registered; available providers are %+v", name, Providers())
+	}
+	return factory()
+}
@@ -0,0 +1,98 @@
+package teams
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nais/tobac/pkg/azure"
+	log "github.com/sirupsen/logrus"
+)
+
+// Resolver retrieves a single team from the team backend. It is used for team
+// identifiers missing from the team cache, e.g. teams created since the last sync.
+type Resolver func(ctx context.Context, id string) (azure.Team, error)
+
+// Guards the resolver configuration and its caches.
+var resolveMutex sync.Mutex
+
+var resolver Resolver
+var resolverTimeout = time.Second
+
+// Teams resolved on demand since the last sync, keyed by normalized identifier.
+var resolved = make(map[string]azure.Team)
+
+// Normalized identifiers that could not be resolved, and when to try them again.
+var misses = make(map[string]time.Time)
+var negativeTTL = 30 * time.Second
+
+// SetResolver configures on-demand lookups of teams missing from the cache.
+func SetResolver(r Resolver, timeout time.Duration) {
+	resolveMutex.Lock()
+	defer resolveMutex.Unlock()
+	resolver = r
+	resolverTimeout = timeout
+}
+
+// SetNegativeTTL configures how long a team identifier that could not be resolved is
+// remembered, so that repeated requests for a non-existing team do not reach the backend.
+func SetNegativeTTL(ttl time.Duration) {
+	resolveMutex.Lock()
+	defer resolveMutex.Unlock()
+	negativeTTL = ttl
+}
+
+// Discard teams resolved on demand, after the cache has been replaced.
+func resetResolved() {
+	resolveMutex.Lock()
+	defer resolveMutex.Unlock()
+	resolved = make(map[string]azure.Team)
+	misses = make(map[string]time.Time)
+}
+
+// Look up a team missing from the cache using the resolver, unless it recently failed to resolve.
+// The lookup is bounded by both the resolver timeout and the context.
+func resolve(ctx context.Context, key string) azure.Team {
+	now := time.Now()
+
+	resolveMutex.Lock()
+	r, timeout, ttl := resolver, resolverTimeout, negativeTTL
+	if team, ok := resolved[key]; ok {
+		resolveMutex.Unlock()
+		return team
+	}
+	expiry, missed := misses[key]
+	resolveMutex.Unlock()
+
+	if r == nil || (missed && now.Before(expiry)) {
+		return azure.Team{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	team, err := r(ctx, key)
+	if err != nil {
+		log.Warnf("while resolving team '%s': %s", key, err)
+	}
+
+	resolveMutex.Lock()
+	defer resolveMutex.Unlock()
+	// A lookup that timed out says nothing about whether the team exists, and is not remembered.
+	if err != nil && ctx.Err() != nil {
+		return azure.Team{}
+	}
+	if err != nil || !team.Valid() {
+		misses[key] = now.Add(ttl)
+		for k, e := range misses {
+			if now.After(e) {
+				delete(misses, k)
+			}
+		}
+		return azure.Team{}
+	}
+
+	log.Infof("Resolved team '%s' missing from the team cache", team.ID)
+	resolved[key] = team
+	return team
+}
@@ -1,49 +1,445 @@
 package teams
 
 import (
-	"strings"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/metrics"
+	"github.com/nais/tobac/pkg/tracing"
 )
 
-var mutex sync.Mutex
-var teamList map[string]azure.Team
+// cache is an immutable snapshot of the team cache. Updates store a modified copy,
+// so that lookups never wait for a sync replacing a large team list.
+type cache struct {
+	teams      map[string]azure.Team
+	index      map[string]string // normalized team IDs and aliases to team IDs
+	configured map[string]string // normalized configured aliases to normalized team IDs
+	synced     bool
+	updated    time.Time
+	source     string              // where the cached teams were retrieved from
+	renamed    map[string][]string // group UUIDs to former team IDs
+}
+
+var current atomic.Value
+
+// Serializes updates to the cache snapshot.
+var updateMutex sync.Mutex
+
+var trigger = make(chan struct{}, 1)
+
+// How long to keep teams that disappear from the team provider.
+var gracePeriod time.Duration
+
+// Fraction of the cached teams a new team list must contain to replace the cache.
+var minimumRatio float64
+
+// How long smaller team lists must be returned before they are accepted.
+var truncationWindow time.Duration
+
+// When the first of the current run of rejected team lists was returned. Only accessed by Sync.
+var truncatedSince time.Time
+
+// SetMinimumRatio configures the fraction of the cached teams a new team list must contain to replace the cache.
+// A sync returning drastically fewer teams is most likely caused by a partial outage of the team provider.
+// Smaller team lists returned by every sync for the duration of window are accepted. A zero ratio disables the check,
+// and a zero window never accepts a smaller team list.
+func SetMinimumRatio(ratio float64, window time.Duration) {
+	minimumRatio = ratio
+	truncationWindow = window
+	truncatedSince = time.Time{}
+}
+
+// Check that a new team list is not drastically smaller than the cached team list.
+func sane(previous, teams map[string]azure.Team, now time.Time) error {
+	active := 0
+	for _, team := range previous {
+		if team.Deleted.IsZero() {
+			active++
+		}
+	}
+	if float64(len(teams)) >= float64(active)*minimumRatio {
+		truncatedSince = time.Time{}
+		return nil
+	}
+
+	if truncatedSince.IsZero() {
+		truncatedSince = now
+	}
+	if truncationWindow > 0 && now.Sub(truncatedSince) >= truncationWindow {
+		log.Warnf("Accepting reduction from %d to %d cached teams, returned since %s", active, len(teams), truncatedSince.Format(time.RFC3339))
+		truncatedSince = time.Time{}
+		return nil
+	}
+
+	return fmt.Errorf("refusing to replace %d cached teams with %d teams; below minimum ratio of %.2f since %s", active, len(teams), minimumRatio, truncatedSince.Format(time.RFC3339))
+}
+
+// SetGracePeriod configures how long teams that disappear from the team provider are kept, marked as deleted.
+func SetGracePeriod(grace time.Duration) {
+	gracePeriod = grace
+}
+
+// Add teams that have disappeared from the provider to the team list, marked as deleted, until the grace period has passed.
+func retainDeleted(previous, teams map[string]azure.Team, now time.Time, grace time.Duration) map[string]azure.Team {
+	if grace <= 0 {
+		return teams
+	}
+	retained := make(map[string]azure.Team, len(teams))
+	uuids := make(map[string]bool)
+	for id, team := range teams {
+		retained[id] = team
+		uuids[team.AzureUUID] = true
+	}
+	for id, team := range previous {
+		if _, ok := teams[id]; ok {
+			continue
+		}
+		// Renamed teams live on as aliases.
+		if len(team.AzureUUID) > 0 && uuids[team.AzureUUID] {
+			continue
+		}
+		if team.Deleted.IsZero() {
+			log.Warnf("Team '%s' has disappeared from the team provider; keeping it as deleted for %s", id, grace)
+			team.Deleted = now
+		}
+		if now.Sub(team.Deleted) >= grace {
+			log.Warnf("Removing team '%s' after grace period", id)
+			continue
+		}
+		retained[id] = team
+	}
+	return retained
+}
+
+// Record the former IDs of teams whose group has been renamed since the previous sync, and add all
+// former IDs to the aliases of the renamed teams. Returns the teams with aliases and the updated former IDs.
+func trackRenames(previous map[string]azure.Team, renamed map[string][]string, teams map[string]azure.Team) (map[string]azure.Team, map[string][]string) {
+	previousIDs := make(map[string]string)
+	for _, team := range previous {
+		if len(team.AzureUUID) > 0 && team.Deleted.IsZero() {
+			previousIDs[team.AzureUUID] = team.ID
+		}
+	}
+
+	tracked := make(map[string]azure.Team, len(teams))
+	formerIDs := make(map[string][]string)
+	for id, team := range teams {
+		tracked[id] = team
+		if len(team.AzureUUID) == 0 {
+			continue
+		}
+
+		former := make([]string, 0)
+		for _, formerID := range renamed[team.AzureUUID] {
+			// Teams may be renamed back.
+			if formerID != team.ID {
+				former = append(former, formerID)
+			}
+		}
+		if previousID, ok := previousIDs[team.AzureUUID]; ok && previousID != team.ID {
+			log.Warnf("Team '%s' has been renamed to '%s'; accepting the former ID as an alias", previousID, team.ID)
+			metrics.TeamRenamed.Inc()
+			former = append(former, previousID)
+		}
+		if len(former) == 0 {
+			continue
+		}
 
-func fetchAzureTeams(timeout time.Duration) (map[string]azure.Team, error) {
-	ctx, cancel := azure.DefaultContext(timeout)
+		formerIDs[team.AzureUUID] = former
+		team.Aliases = append(append([]string{}, team.Aliases...), former...)
+		tracked[id] = team
+	}
+
+	return tracked, formerIDs
+}
+
+func init() {
+	current.Store(&cache{})
+}
+
+func load() *cache {
+	return current.Load().(*cache)
+}
+
+// Store a modified copy of the current cache snapshot. Nothing is stored if modify returns false.
+func update(modify func(c *cache) bool) bool {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	next := *load()
+	if !modify(&next) {
+		return false
+	}
+	current.Store(&next)
+	return true
+}
+
+// Replace the cached teams, retrieved from the team provider at the specified time.
+func replace(c *cache, teams map[string]azure.Team, updated time.Time) {
+	c.teams = teams
+	c.index = aliasIndex(teams)
+	c.updated = updated
+	metrics.TeamCacheUpdated.Set(float64(c.updated.Unix()))
+	metrics.TeamsCached.Set(float64(len(teams)))
+}
+
+// Updated returns the time the team cache was last updated, either by synchronization or from a snapshot.
+// The zero time is returned if the cache has never been populated.
+func Updated() time.Time {
+	return load().updated
+}
+
+func fetch(ctx context.Context, provider Provider, timeout time.Duration) (map[string]azure.Team, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	return azure.Teams(ctx)
+	ctx, span := tracing.Start(ctx, "team provider sync", tracing.Client)
+	defer span.End()
+	teams, err := provider.Teams(ctx)
+	span.SetAttribute("teams", len(teams))
+	span.SetError(err)
+	return teams, err
+}
+
+// Wait until the next sync is due, either because the interval has passed,
+// because the provider signals that its teams have changed, or because a sync was triggered.
+// Returns false if the context is done.
+func wait(ctx context.Context, timer *time.Timer, changes <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	case <-timer.C:
+		return true
+	case <-changes:
+		log.Infof("Team provider signaled changes")
+	case <-trigger:
+		log.Infof("Team synchronization triggered")
+	}
+	if !timer.Stop() {
+		<-timer.C
+	}
+	return true
+}
+
+// Trigger starts a synchronization immediately, instead of waiting for the next interval.
+// Triggers received while a synchronization is pending are coalesced.
+func Trigger() {
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Synchronized is the team list retrieved by a successful sync, and the time it was retrieved.
+// Teams include the former IDs of renamed teams as aliases, which are also listed in Renamed.
+type Synchronized struct {
+	Teams   map[string]azure.Team
+	Renamed map[string][]string // group UUIDs to former team IDs
+	Synced  time.Time
+}
+
+// Snapshot is called with the complete team list after every successful sync.
+type Snapshot func(Synchronized) error
+
+// Randomize an interval by up to the given fraction in either direction,
+// so that several replicas do not query the team provider simultaneously.
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	delta := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(delta)
 }
 
-// Sync keeps local copy of teamList in sync
-func Sync(interval, timeout time.Duration) {
+// Sync keeps local copy of teamList in sync with the team provider, until the context is done.
+// The first sync starts immediately, and subsequent syncs are spaced by the jittered interval.
+func Sync(ctx context.Context, provider Provider, interval, timeout time.Duration, jitter float64, snapshot Snapshot) {
+	var changes <-chan struct{}
+	if notifier, ok := provider.(Notifier); ok {
+		changes = notifier.Changes()
+	}
+
 	timer := time.NewTimer(interval)
 
 	for {
-		timer.Reset(interval)
-		log.Infof("Retrieving teams from MS Graph API")
-		teams, err := fetchAzureTeams(timeout)
+		timer.Reset(jittered(interval, jitter))
+		log.Infof("Retrieving teams from team provider")
+		teams, err := fetch(ctx, provider, timeout)
 		if err != nil {
 			log.Errorf("while retrieving teams: %s", err)
-			<-timer.C
+			metrics.TeamSyncErrors.Inc()
+			if !wait(ctx, timer, changes) {
+				break
+			}
 			continue
 		}
-		mutex.Lock()
-		teamList = teams
-		mutex.Unlock()
-		log.Infof("Cached %d teams from Azure AD", len(teamList))
-		<-timer.C
+		if err := sane(load().teams, teams, time.Now()); err != nil {
+			log.Errorf("while retrieving teams: %s", err)
+			metrics.TeamSyncRejected.Set(1)
+			metrics.TeamSyncErrors.Inc()
+			if !wait(ctx, timer, changes) {
+				break
+			}
+			continue
+		}
+		metrics.TeamSyncRejected.Set(0)
+		synchronized := Synchronized{Synced: time.Now()}
+		update(func(c *cache) bool {
+			synchronized.Teams, c.renamed = trackRenames(c.teams, c.renamed, teams)
+			synchronized.Renamed = c.renamed
+			replace(c, retainDeleted(c.teams, synchronized.Teams, synchronized.Synced, gracePeriod), synchronized.Synced)
+			c.synced = true
+			c.source = source(provider)
+			return true
+		})
+		resetResolved()
+		metrics.TeamSyncLastSuccess.Set(float64(time.Now().Unix()))
+		log.Infof("Cached %d teams from team provider", len(teams))
+		if snapshot != nil {
+			if err := snapshot(synchronized); err != nil {
+				log.Errorf("while writing team snapshot: %s", err)
+			}
+		}
+		if !wait(ctx, timer, changes) {
+			break
+		}
 	}
+
+	log.Infof("Stopped team synchronization")
 }
 
-// Get returns a team with the specified identified
+func source(provider Provider) string {
+	if sourcer, ok := provider.(Sourcer); ok {
+		return sourcer.Source()
+	}
+	return "provider"
+}
+
+// Seed populates the team cache from a previous snapshot, unless teams have
+// already been retrieved from the team provider. Returns true if the cache was populated.
+// The cache is considered updated when the snapshot was synchronized, or now if that is unknown.
+// A snapshot that has already been seeded is ignored, and a snapshot with unchanged teams only
+// updates the time, keeping teams resolved on demand.
+func Seed(snapshot Synchronized) bool {
+	changed := false
+	seeded := update(func(c *cache) bool {
+		if c.synced {
+			return false
+		}
+		unchanged := c.teams != nil && reflect.DeepEqual(c.teams, snapshot.Teams) && reflect.DeepEqual(c.renamed, snapshot.Renamed)
+		updated := snapshot.Synced
+		if updated.IsZero() {
+			if unchanged {
+				return false
+			}
+			updated = time.Now()
+		}
+		if updated.Equal(c.updated) {
+			return false
+		}
+		if unchanged {
+			c.updated = updated
+			metrics.TeamCacheUpdated.Set(float64(updated.Unix()))
+			return true
+		}
+		replace(c, snapshot.Teams, updated)
+		c.renamed = snapshot.Renamed
+		c.source = "snapshot"
+		changed = true
+		return true
+	})
+	if changed {
+		resetResolved()
+	}
+	// Followers never sync themselves, and report when the leader last synchronized.
+	if seeded && !snapshot.Synced.IsZero() {
+		metrics.TeamSyncLastSuccess.Set(float64(snapshot.Synced.Unix()))
+	}
+	return seeded
+}
+
+// Synced returns true once teams have been retrieved from the team provider.
+func Synced() bool {
+	return load().synced
+}
+
+// Build a lookup table from normalized team IDs and aliases to team IDs.
+// Team IDs take precedence over aliases.
+func aliasIndex(teams map[string]azure.Team) map[string]string {
+	index := make(map[string]string)
+	for _, team := range teams {
+		for _, alias := range team.Aliases {
+			index[Normalize(alias)] = team.ID
+		}
+	}
+	for _, team := range teams {
+		index[Normalize(team.ID)] = team.ID
+	}
+	return index
+}
+
+// SetAliases configures additional aliases for teams, mapping team IDs to lists of aliases.
+func SetAliases(aliases map[string][]string) {
+	index := make(map[string]string)
+	for id, teamAliases := range aliases {
+		for _, alias := range teamAliases {
+			index[Normalize(alias)] = Normalize(id)
+		}
+	}
+	update(func(c *cache) bool {
+		c.configured = index
+		return true
+	})
+}
+
+// Members returns the members of a team. Members held in the team cache are returned
+// if present, otherwise they are listed by the provider, if it is able to.
+func Members(ctx context.Context, provider Provider, team azure.Team) ([]string, error) {
+	if len(team.Members) > 0 {
+		return team.Members, nil
+	}
+	if lister, ok := provider.(MemberLister); ok {
+		ctx, span := tracing.Start(ctx, "team provider members", tracing.Client)
+		defer span.End()
+		span.SetAttribute("team", team.ID)
+		members, err := lister.Members(ctx, team)
+		span.SetError(err)
+		return members, err
+	}
+	return nil, nil
+}
+
+// Get returns a team with the specified identifier, after normalization.
+// If no team is found with that identifier, teams are looked up by their aliases.
+// Teams missing from the cache are looked up on demand, if a resolver is configured.
 func Get(id string) azure.Team {
-	id = strings.ToLower(id)
-	mutex.Lock()
-	defer mutex.Unlock()
-	return teamList[id]
+	return GetContext(context.Background(), id)
+}
+
+// GetContext is like Get, but gives up looking up teams on demand when the context is done.
+func GetContext(ctx context.Context, id string) azure.Team {
+	key := Normalize(id)
+	if team, ok := cached(key); ok {
+		return team
+	}
+	return resolve(ctx, key)
+}
+
+func cached(key string) (azure.Team, bool) {
+	c := load()
+	if canonical, ok := c.index[key]; ok {
+		return c.teams[canonical], true
+	}
+	if canonical, ok := c.configured[key]; ok {
+		team, found := c.teams[c.index[canonical]]
+		return team, found
+	}
+	return azure.Team{}, false
 }
@@ -0,0 +1,323 @@
+package teams_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nais/tobac/pkg/azure"
+	"github.com/nais/tobac/pkg/metrics"
+	"github.com/nais/tobac/pkg/teams"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeed(t *testing.T) {
+	assert.False(t, teams.Synced())
+	assert.True(t, teams.Updated().IsZero())
+	synced := time.Now().Add(-time.Hour).Round(time.Second)
+	assert.True(t, teams.Seed(teams.Synchronized{
+		Teams: map[string]azure.Team{
+			"team-a": {ID: "team-a", AzureUUID: "uuid-a", Aliases: []string{"old-a"}},
+		},
+		Synced: synced,
+	}))
+	assert.Equal(t, synced, teams.Updated(), "the cache is as old as the snapshot")
+	assert.Equal(t, float64(synced.Unix()), testutil.ToFloat64(metrics.TeamSyncLastSuccess))
+	assert.False(t, teams.Seed(teams.Synchronized{
+		Teams: map[string]azure.Team{
+			"team-a": {ID: "team-a", AzureUUID: "uuid-a", Aliases: []string{"old-a"}},
+		},
+		Synced: synced,
+	}), "a snapshot is only seeded once")
+	assert.True(t, teams.Seed(teams.Synchronized{
+		Teams: map[string]azure.Team{
+			"team-a": {ID: "team-a", AzureUUID: "uuid-a", Aliases: []string{"old-a"}},
+		},
+		Synced: synced.Add(time.Minute),
+	}))
+	assert.Equal(t, synced.Add(time.Minute), teams.Updated(), "a newer snapshot updates the time")
+	assert.Equal(t, "uuid-a", teams.Get("team-a").AzureUUID)
+	assert.Equal(t, "uuid-a", teams.Get("old-a").AzureUUID)
+}
+
+func TestExport(t *testing.T) {
+	export, err := teams.NewExport()
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot", export.Source)
+	assert.Len(t, export.Teams, 1)
+	assert.Equal(t, "uuid-a", export.Teams[0].AzureUUID)
+	assert.Len(t, export.Hash, 64)
+
+	again, err := teams.NewExport()
+	assert.NoError(t, err)
+	assert.Equal(t, export.Hash, again.Hash, "hash does not depend on the export time")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, export.WriteCSV(buf))
+	assert.Equal(t, "id,title,azure_uuid,aliases,groups,members,deleted\nteam-a,,uuid-a,old-a,,,\n", buf.String())
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "team-a", teams.Normalize("Team-A"))
+	assert.Equal(t, " team-a ", teams.Normalize(" Team-A "))
+
+	assert.Error(t, teams.SetNormalization([]string{"unknown"}))
+	assert.NoError(t, teams.SetNormalization([]string{teams.NormalizeTrim, teams.NormalizeFold}))
+	defer teams.SetNormalization([]string{teams.NormalizeLowercase})
+
+	assert.Equal(t, "team-a", teams.Normalize(" Team-A "))
+	assert.Equal(t, "team-a", teams.Normalize("ｔｅａｍ-Ａ"))
+}
+
+func TestResolveNegativeCache(t *testing.T) {
+	lookups := 0
+	teams.SetResolver(func(ctx context.Context, id string) (azure.Team, error) {
+		lookups++
+		if id == "new-team" {
+			return azure.Team{ID: id, AzureUUID: "uuid-new"}, nil
+		}
+		return azure.Team{}, nil
+	}, time.Second)
+	defer teams.SetResolver(nil, time.Second)
+	teams.SetNegativeTTL(time.Hour)
+
+	assert.Equal(t, "uuid-new", teams.Get("new-team").AzureUUID)
+	assert.Equal(t, "uuid-new", teams.Get("new-team").AzureUUID)
+	assert.Equal(t, 1, lookups)
+
+	assert.False(t, teams.Get("missing").Valid())
+	assert.False(t, teams.Get("missing").Valid())
+	assert.Equal(t, 2, lookups, "missing teams are cached")
+}
+
+func TestResolveHonorsDeadline(t *testing.T) {
+	lookups := 0
+	teams.SetResolver(func(ctx context.Context, id string) (azure.Team, error) {
+		lookups++
+		<-ctx.Done()
+		return azure.Team{}, ctx.Err()
+	}, time.Hour)
+	defer teams.SetResolver(nil, time.Second)
+	teams.SetNegativeTTL(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, teams.GetContext(ctx, "slow-team").Valid())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, teams.GetContext(ctx, "slow-team").Valid())
+	assert.Equal(t, 2, lookups, "timed out lookups are not cached")
+}
+
+func TestCompositeTeamLookup(t *testing.T) {
+	composite, err := teams.NewComposite(teams.MergePriority, teams.ProviderFunc(nil), lookupProvider{
+		"new-team": {ID: "new-team", AzureUUID: "uuid-new"},
+	})
+	assert.NoError(t, err)
+
+	team, err := composite.Team(context.Background(), "new-team")
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid-new", team.AzureUUID)
+
+	team, err = composite.Team(context.Background(), "missing")
+	assert.NoError(t, err)
+	assert.False(t, team.Valid())
+}
+
+type lookupProvider map[string]azure.Team
+
+func (p lookupProvider) Teams(ctx context.Context) (map[string]azure.Team, error) {
+	return p, nil
+}
+
+func (p lookupProvider) Team(ctx context.Context, id string) (azure.Team, error) {
+	return p[id], nil
+}
+
+func TestSyncStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		return map[string]azure.Team{
+			"synced": {ID: "synced", AzureUUID: "uuid-synced"},
+		}, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(teams.Synchronized) error {
+			cancel()
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sync did not stop when the context was cancelled")
+	}
+	assert.True(t, teams.Synced())
+	assert.Equal(t, "uuid-synced", teams.Get("synced").AzureUUID)
+}
+
+func TestDeletedTeamGracePeriod(t *testing.T) {
+	teams.SetGracePeriod(time.Hour)
+	defer teams.SetGracePeriod(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	syncs := 0
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		syncs++
+		if syncs == 1 {
+			return map[string]azure.Team{
+				"doomed": {ID: "doomed", AzureUUID: "uuid-doomed"},
+			}, nil
+		}
+		return map[string]azure.Team{}, nil
+	})
+
+	teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(synchronized teams.Synchronized) error {
+		teamList := synchronized.Teams
+		if syncs == 1 {
+			assert.True(t, teams.Get("doomed").Deleted.IsZero())
+			teams.Trigger()
+		} else {
+			assert.NotContains(t, teamList, "doomed", "deleted teams are not written to snapshots")
+			cancel()
+		}
+		return nil
+	})
+
+	team := teams.Get("doomed")
+	assert.True(t, team.Valid())
+	assert.False(t, team.Deleted.IsZero())
+}
+
+func TestSyncRejectsTruncatedTeamList(t *testing.T) {
+	teams.SetMinimumRatio(0.5, 0)
+	defer teams.SetMinimumRatio(0, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	syncs := 0
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		syncs++
+		teamList := map[string]azure.Team{
+			"one": {ID: "one", AzureUUID: "uuid-one"},
+		}
+		switch syncs {
+		case 1:
+			for _, id := range []string{"two", "three", "four"} {
+				teamList[id] = azure.Team{ID: id, AzureUUID: "uuid-" + id}
+			}
+		case 2:
+			cancel()
+		}
+		return teamList, nil
+	})
+
+	teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(teams.Synchronized) error {
+		teams.Trigger()
+		return nil
+	})
+
+	assert.Equal(t, 2, syncs)
+	assert.True(t, teams.Get("four").Valid(), "cached teams are kept when a truncated list is rejected")
+}
+
+func TestSyncAcceptsConsistentlyTruncatedTeamList(t *testing.T) {
+	teams.SetMinimumRatio(0.5, 10*time.Millisecond)
+	defer teams.SetMinimumRatio(0, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	syncs := 0
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		syncs++
+		teamList := map[string]azure.Team{
+			"one": {ID: "one", AzureUUID: "uuid-one"},
+		}
+		if syncs == 1 {
+			for _, id := range []string{"two", "three", "four"} {
+				teamList[id] = azure.Team{ID: id, AzureUUID: "uuid-" + id}
+			}
+		}
+		if syncs == 3 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if syncs < 3 {
+			teams.Trigger()
+		}
+		return teamList, nil
+	})
+
+	teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(synchronized teams.Synchronized) error {
+		if len(synchronized.Teams) == 1 {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.Equal(t, 3, syncs, "the smaller list is accepted once it has been returned for the truncation window")
+	assert.False(t, teams.Get("four").Valid())
+}
+
+func TestSyncMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errors := testutil.ToFloat64(metrics.TeamSyncErrors)
+	syncs := 0
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		syncs++
+		if syncs == 1 {
+			teams.Trigger()
+			return nil, fmt.Errorf("unavailable")
+		}
+		return map[string]azure.Team{
+			"one": {ID: "one", AzureUUID: "uuid-one"},
+			"two": {ID: "two", AzureUUID: "uuid-two"},
+		}, nil
+	})
+
+	teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(teams.Synchronized) error {
+		cancel()
+		return nil
+	})
+
+	assert.Equal(t, errors+1, testutil.ToFloat64(metrics.TeamSyncErrors))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.TeamsCached))
+	assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(metrics.TeamSyncLastSuccess), 5)
+}
+
+func TestRenamedTeamKeepsFormerID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	names := []string{"before", "after", "latest"}
+	syncs := 0
+	provider := teams.ProviderFunc(func(ctx context.Context) (map[string]azure.Team, error) {
+		name := names[syncs]
+		syncs++
+		return map[string]azure.Team{
+			name: {ID: name, AzureUUID: "uuid-renamed"},
+		}, nil
+	})
+
+	var snapshot teams.Synchronized
+	teams.Sync(ctx, provider, time.Hour, time.Second, 0, func(synchronized teams.Synchronized) error {
+		snapshot = synchronized
+		if syncs < len(names) {
+			teams.Trigger()
+		} else {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.ElementsMatch(t, []string{"before", "after"}, snapshot.Teams["latest"].Aliases, "former IDs are written to snapshots")
+	assert.ElementsMatch(t, []string{"before", "after"}, snapshot.Renamed["uuid-renamed"])
+	for _, name := range names {
+		team := teams.Get(name)
+		assert.Equal(t, "latest", team.ID)
+		assert.True(t, team.Deleted.IsZero())
+	}
+}
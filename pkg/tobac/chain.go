@@ -0,0 +1,256 @@
+package tobac
+
+import (
+	"fmt"
+
+	"github.com/nais/tobac/pkg/azure"
+)
+
+// State carries information resolved by a checker on to the checkers following it in the chain.
+type State struct {
+	TeamID        string
+	Team          azure.Team
+	ExistingLabel string
+	ExistingTeam  azure.Team
+	Warnings      []string
+}
+
+// Warn adds a non-fatal finding to the final response.
+func (s *State) Warn(format string, a ...interface{}) {
+	s.Warnings = append(s.Warnings, fmt.Sprintf(format, a...))
+}
+
+// Returns the canonical ID of the team the request was decided for, if resolved.
+func (s *State) owner() string {
+	if len(s.Team.ID) > 0 {
+		return s.Team.ID
+	}
+	return s.TeamID
+}
+
+// Checker is a single step in the decision chain.
+// A checker returns a response if it reaches a final decision,
+// or nil if the request should be passed on to the next checker.
+type Checker interface {
+	Name() string
+	Check(request Request, state *State) *Response
+}
+
+// Chain is an ordered list of checkers. Requests not decided by any checker are denied.
+type Chain struct {
+	checkers []Checker
+}
+
+var defaultChain = DefaultChain()
+
+// NewChain returns a chain consisting of the specified checkers.
+func NewChain(checkers ...Checker) *Chain {
+	return &Chain{
+		checkers: checkers,
+	}
+}
+
+// DefaultChain returns a new chain containing all built-in checkers.
+func DefaultChain() *Chain {
+	return NewChain(
+		ClusterAdminChecker{},
+		OnBehalfOfChecker{},
+		AdminOnlyOperationChecker{},
+		TeamLabelChecker{},
+		DeletedTeamChecker{},
+		ExistingTeamChecker{},
+		ImmutableTeamLabelChecker{},
+		AnnexationChecker{},
+		NamespaceTeamChecker{},
+		DeniedKindsChecker{},
+		MembershipChecker{},
+		ServiceUserChecker{},
+	)
+}
+
+func (c *Chain) index(name string) int {
+	for i, checker := range c.checkers {
+		if checker.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Chain) insert(i int, checker Checker) {
+	c.checkers = append(c.checkers, nil)
+	copy(c.checkers[i+1:], c.checkers[i:])
+	c.checkers[i] = checker
+}
+
+// Select returns a new chain with only the named checkers, in the order they have in this chain.
+func (c *Chain) Select(names ...string) (*Chain, error) {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		if c.index(name) < 0 {
+			return nil, fmt.Errorf("checker '%s' is not registered", name)
+		}
+		selected[name] = true
+	}
+	chain := NewChain()
+	for _, checker := range c.checkers {
+		if selected[checker.Name()] {
+			chain.Append(checker)
+		}
+	}
+	return chain, nil
+}
+
+// Without returns a new chain without the named checkers. Names not in this chain are ignored.
+func (c *Chain) Without(names ...string) *Chain {
+	removed := make(map[string]bool, len(names))
+	for _, name := range names {
+		removed[name] = true
+	}
+	chain := NewChain()
+	for _, checker := range c.checkers {
+		if !removed[checker.Name()] {
+			chain.Append(checker)
+		}
+	}
+	return chain
+}
+
+// Names returns the names of all checkers in evaluation order.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.checkers))
+	for i, checker := range c.checkers {
+		names[i] = checker.Name()
+	}
+	return names
+}
+
+// Append adds a checker to the end of the chain.
+func (c *Chain) Append(checker Checker) {
+	c.checkers = append(c.checkers, checker)
+}
+
+// InsertBefore adds a checker immediately before the named checker.
+func (c *Chain) InsertBefore(name string, checker Checker) error {
+	i := c.index(name)
+	if i < 0 {
+		return fmt.Errorf("checker '%s' is not registered", name)
+	}
+	c.insert(i, checker)
+	return nil
+}
+
+// InsertAfter adds a checker immediately after the named checker.
+func (c *Chain) InsertAfter(name string, checker Checker) error {
+	i := c.index(name)
+	if i < 0 {
+		return fmt.Errorf("checker '%s' is not registered", name)
+	}
+	c.insert(i+1, checker)
+	return nil
+}
+
+// Remove deletes the named checker from the chain.
+func (c *Chain) Remove(name string) error {
+	i := c.index(name)
+	if i < 0 {
+		return fmt.Errorf("checker '%s' is not registered", name)
+	}
+	c.checkers = append(c.checkers[:i], c.checkers[i+1:]...)
+	return nil
+}
+
+// Allowed runs the request through all checkers in order, and returns the first decision made.
+func (c *Chain) Allowed(request Request) Response {
+	response, _ := c.evaluate(request, nil)
+	return response
+}
+
+// Step describes the outcome of a single checker.
+type Step struct {
+	Checker   string
+	Evaluated bool // false if an earlier checker decided the request
+	Decided   bool
+	Allowed   bool
+	Reason    string
+	Warnings  []string // warnings added by this checker
+}
+
+// Explanation describes how a request was decided.
+type Explanation struct {
+	Response Response
+	Steps    []Step
+	State    State
+}
+
+// Explain runs the request through all checkers like Allowed, recording the outcome of every checker
+// and the state resolved along the way.
+func (c *Chain) Explain(request Request) Explanation {
+	explanation := Explanation{
+		Steps: make([]Step, len(c.checkers)),
+	}
+	for i, checker := range c.checkers {
+		explanation.Steps[i].Checker = checker.Name()
+	}
+	response, state := c.evaluate(request, explanation.Steps)
+	explanation.Response = response
+	explanation.State = *state
+	return explanation
+}
+
+// Run the request through the checkers, recording their outcomes in steps if not nil.
+func (c *Chain) evaluate(request Request, steps []Step) (Response, *State) {
+	state := &State{}
+
+	for i, checker := range c.checkers {
+		warnings := len(state.Warnings)
+		response := checker.Check(request, state)
+		if steps != nil {
+			steps[i].Evaluated = true
+			steps[i].Warnings = append([]string(nil), state.Warnings[warnings:]...)
+			if response != nil {
+				steps[i].Decided = true
+				steps[i].Allowed = response.Allowed
+				steps[i].Reason = response.Reason
+				steps[i].Warnings = append(steps[i].Warnings, response.Warnings...)
+			}
+		}
+		if response != nil {
+			// Denials by checkers not setting a code are attributed to the checker.
+			if !response.Allowed && len(response.Code) == 0 {
+				response.Code = checker.Name()
+			}
+			response.Warnings = append(state.Warnings, response.Warnings...)
+			response.Team = state.owner()
+			return *response, state
+		}
+	}
+
+	// default deny
+	reason := fmt.Sprintf(ErrorUserHasNoAccessToTeam, request.UserInfo.Username, state.TeamID)
+	if len(state.Team.Contact) > 0 {
+		reason = fmt.Sprintf(ErrorUserHasNoAccessToTeamContact, request.UserInfo.Username, state.TeamID, state.Team.Contact)
+	}
+	return Response{Allowed: false, Code: CodeUserHasNoAccessToTeam, Reason: reason, Team: state.owner(), Warnings: state.Warnings}, state
+}
+
+// Default returns the default chain, including registered checkers.
+func Default() *Chain {
+	return defaultChain
+}
+
+// Register adds a checker to the end of the default chain.
+// Registration is not thread safe, and must be done before any requests are evaluated.
+func Register(checker Checker) {
+	defaultChain.Append(checker)
+}
+
+// RegisterBefore adds a checker to the default chain, immediately before the named checker.
+func RegisterBefore(name string, checker Checker) error {
+	return defaultChain.InsertBefore(name, checker)
+}
+
+// RegisterAfter adds a checker to the default chain, immediately after the named checker.
+func RegisterAfter(name string, checker Checker) error {
+	return defaultChain.InsertAfter(name, checker)
+}
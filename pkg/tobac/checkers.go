@@ -0,0 +1,341 @@
+package tobac
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nais/tobac/pkg/azure"
+)
+
+const (
+	CheckerClusterAdmin = "cluster-admin"
+	CheckerOnBehalfOf   = "on-behalf-of"
+	CheckerAdminOnly    = "admin-only-operation"
+	CheckerTeamLabel    = "team-label"
+	CheckerDeletedTeam  = "deleted-team"
+	CheckerExistingTeam = "existing-team"
+	CheckerImmutable    = "immutable-team-label"
+	CheckerAnnexation   = "annexation"
+	CheckerNamespace    = "namespace-team"
+	CheckerDeniedKinds  = "denied-kinds"
+	CheckerMembership   = "membership"
+	CheckerServiceUser  = "service-user"
+)
+
+// ClusterAdminChecker allows any request from a cluster administrator.
+type ClusterAdminChecker struct{}
+
+func (c ClusterAdminChecker) Name() string {
+	return CheckerClusterAdmin
+}
+
+func (c ClusterAdminChecker) Check(request Request, state *State) *Response {
+	response := ClusterAdminResponse(request)
+	if response == nil || request.SubmittedResource == nil {
+		return response
+	}
+
+	// Administrators acting on behalf of a team must specify a team that exists.
+	teamID := request.SubmittedResource.GetAnnotations()[AnnotationOnBehalfOf]
+	if len(teamID) == 0 {
+		return response
+	}
+	team := request.TeamProvider(teamID)
+	if !team.Valid() {
+		return &Response{Allowed: false, Code: CodeOnBehalfOf, Reason: fmt.Sprintf(ErrorOnBehalfOfTeamDoesNotExist, teamID, AnnotationOnBehalfOf)}
+	}
+
+	response.OnBehalfOf = team.ID
+	response.Reason = fmt.Sprintf(SuccessUserIsClusterAdminOnBehalfOf, adminGroup(request), team.ID)
+
+	return response
+}
+
+// OnBehalfOfChecker denies anyone but cluster administrators from setting or changing the on-behalf-of annotation.
+// Resources that already carry the annotation may still be updated by their owners.
+type OnBehalfOfChecker struct{}
+
+func (c OnBehalfOfChecker) Name() string {
+	return CheckerOnBehalfOf
+}
+
+func (c OnBehalfOfChecker) Check(request Request, state *State) *Response {
+	if request.SubmittedResource == nil {
+		return nil
+	}
+
+	submitted, found := request.SubmittedResource.GetAnnotations()[AnnotationOnBehalfOf]
+	if !found {
+		return nil
+	}
+
+	if request.ExistingResource != nil {
+		existing, found := request.ExistingResource.GetAnnotations()[AnnotationOnBehalfOf]
+		if found && existing == submitted {
+			return nil
+		}
+	}
+
+	return &Response{Allowed: false, Code: CodeOnBehalfOf, Reason: fmt.Sprintf(ErrorOnBehalfOfRequiresClusterAdmin, AnnotationOnBehalfOf)}
+}
+
+// AdminOnlyOperationChecker denies operations that are reserved for cluster administrators in the current environment.
+type AdminOnlyOperationChecker struct{}
+
+func (c AdminOnlyOperationChecker) Name() string {
+	return CheckerAdminOnly
+}
+
+func (c AdminOnlyOperationChecker) Check(request Request, state *State) *Response {
+	for _, operation := range request.AdminOnlyOperations {
+		if operation.Matches(request) {
+			return &Response{Allowed: false, Code: CodeRequiresClusterAdmin, Reason: fmt.Sprintf(ErrorOperationRequiresClusterAdmin, strings.ToLower(request.Operation), request.Environment)}
+		}
+	}
+	return nil
+}
+
+// TeamLabelChecker requires submitted resources to be labeled with an existing team.
+type TeamLabelChecker struct{}
+
+func (c TeamLabelChecker) Name() string {
+	return CheckerTeamLabel
+}
+
+func (c TeamLabelChecker) Check(request Request, state *State) *Response {
+	if request.SubmittedResource == nil {
+		return nil
+	}
+
+	// Deny if object is not tagged with a team label.
+	state.TeamID = request.SubmittedResource.GetLabels()["team"]
+	if len(state.TeamID) == 0 {
+		return &Response{Allowed: false, Code: CodeNotTaggedWithTeamLabel, Reason: ErrorNotTaggedWithTeamLabel}
+	}
+
+	// Deny if specified team does not exist
+	state.Team = request.TeamProvider(state.TeamID)
+	if !state.Team.Valid() {
+		return &Response{Allowed: false, Code: CodeTeamDoesNotExist, Reason: fmt.Sprintf(ErrorTeamDoesNotExistInAzureAD, state.TeamID)}
+	}
+
+	// The team label might refer to the team by one of its former names.
+	if !strings.EqualFold(state.Team.ID, state.TeamID) {
+		state.Warn(WarningTeamLabelIsAlias, state.TeamID, state.Team.ID)
+	}
+
+	return nil
+}
+
+// DeletedTeamChecker denies creating resources for teams that have been deleted from the team provider,
+// while they are kept during a grace period. Other operations are allowed to proceed with a warning.
+type DeletedTeamChecker struct{}
+
+func (c DeletedTeamChecker) Name() string {
+	return CheckerDeletedTeam
+}
+
+func (c DeletedTeamChecker) Check(request Request, state *State) *Response {
+	if state.Team.Deleted.IsZero() {
+		return nil
+	}
+	if strings.EqualFold(request.Operation, "CREATE") {
+		return &Response{Allowed: false, Code: CodeTeamIsDeleted, Reason: fmt.Sprintf(ErrorTeamIsDeleted, state.Team.ID)}
+	}
+	state.Warn(WarningTeamIsDeleted, state.Team.ID)
+	return nil
+}
+
+// ExistingTeamChecker requires that the user has access to modify the original resource.
+// Deletes are decided here, since there is no new resource to check.
+type ExistingTeamChecker struct{}
+
+func (c ExistingTeamChecker) Name() string {
+	return CheckerExistingTeam
+}
+
+func (c ExistingTeamChecker) Check(request Request, state *State) *Response {
+	if request.ExistingResource == nil {
+		return nil
+	}
+
+	state.ExistingLabel = request.ExistingResource.GetLabels()["team"]
+
+	// If the existing resource does not have a team label, skip permission checks.
+	if len(state.ExistingLabel) > 0 {
+
+		// Deny if existing team does not exist.
+		state.ExistingTeam = request.TeamProvider(state.ExistingLabel)
+		if !state.ExistingTeam.Valid() {
+			return &Response{Allowed: false, Code: CodeTeamDoesNotExist, Reason: fmt.Sprintf(ErrorExistingTeamDoesNotExistInAzureAD, state.ExistingLabel)}
+		}
+
+		// If user doesn't belong to the correct team, nor is in the service account access list, deny access.
+		isServiceUser, denied := serviceUserAccess(request, state.ExistingTeam.ID)
+		if !isMember(request, state.ExistingTeam) && !isServiceUser {
+			if denied != nil {
+				return denied
+			}
+			return &Response{Allowed: false, Code: CodeUserHasNoAccessToTeam, Reason: fmt.Sprintf(ErrorUserHasNoAccessToTeam, request.UserInfo.Username, state.ExistingTeam.ID)}
+		}
+
+		// Allow deletes here, since there is no new resource to check, unless the team may not manage the kind.
+		if request.SubmittedResource == nil {
+			if kindDenied(request, state.ExistingTeam) {
+				return &Response{Allowed: false, Code: CodeTeamMayNotManageKind, Reason: fmt.Sprintf(ErrorTeamMayNotManageKind, state.ExistingTeam.ID, request.Kind)}
+			}
+			if isServiceUser {
+				return &Response{Allowed: true, Reason: SuccessUserMatchesServiceUserTemplate}
+			}
+			return &Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserBelongsToTeam, state.ExistingLabel)}
+		}
+	}
+
+	// Allow deletes here, since there is no new resource to check
+	if request.SubmittedResource == nil {
+		return &Response{Allowed: true, Reason: SuccessUserMayAnnexateOrphanResource}
+	}
+
+	return nil
+}
+
+// ImmutableTeamLabelChecker denies changes to the team label of existing resources,
+// if the request has immutable team labels enabled.
+type ImmutableTeamLabelChecker struct{}
+
+func (c ImmutableTeamLabelChecker) Name() string {
+	return CheckerImmutable
+}
+
+func (c ImmutableTeamLabelChecker) Check(request Request, state *State) *Response {
+	if !request.ImmutableTeamLabel || request.ExistingResource == nil || request.SubmittedResource == nil {
+		return nil
+	}
+
+	// Orphan resources may still be annexed.
+	if len(state.ExistingLabel) == 0 || state.ExistingLabel == state.TeamID {
+		return nil
+	}
+
+	// Replacing a team alias with the current team name is not a change of ownership.
+	if state.ExistingTeam.Valid() && state.ExistingTeam.ID == state.Team.ID {
+		return nil
+	}
+
+	return &Response{Allowed: false, Code: CodeTeamLabelIsImmutable, Reason: fmt.Sprintf(ErrorTeamLabelIsImmutable, state.ExistingLabel, state.TeamID)}
+}
+
+// AnnexationChecker denies annexation of orphan resources in namespaces not owned by the submitting team,
+// if the request has restricted annexation enabled. Cluster-scoped resources are not restricted.
+type AnnexationChecker struct{}
+
+func (c AnnexationChecker) Name() string {
+	return CheckerAnnexation
+}
+
+func (c AnnexationChecker) Check(request Request, state *State) *Response {
+	if !request.RestrictAnnexation || request.ExistingResource == nil || request.SubmittedResource == nil {
+		return nil
+	}
+	if len(state.ExistingLabel) > 0 || len(request.Namespace) == 0 {
+		return nil
+	}
+
+	namespace, err := request.NamespaceProvider(request.Namespace)
+	if err != nil {
+		return &Response{Allowed: false, Code: CodeAnnexation, Reason: fmt.Sprintf(ErrorAnnexationNamespaceLookup, request.Namespace, err)}
+	}
+
+	namespaceTeam := namespace.GetLabels()["team"]
+	if !sameTeam(request, namespaceTeam, state.Team) {
+		return &Response{Allowed: false, Code: CodeAnnexation, Reason: fmt.Sprintf(ErrorAnnexationOutsideTeamNamespace, state.Team.ID, request.Namespace, namespaceTeam)}
+	}
+
+	return nil
+}
+
+// Returns true if a team label refers to the team, looking it up through the team provider so that
+// team identifier normalization and aliases apply as they do to the resource's own team label.
+func sameTeam(request Request, label string, team azure.Team) bool {
+	return len(label) > 0 && request.TeamProvider(label).ID == team.ID
+}
+
+// NamespaceTeamChecker warns when a resource is owned by another team than its namespace,
+// if the request has namespace team warnings enabled. It never makes a decision.
+type NamespaceTeamChecker struct{}
+
+func (c NamespaceTeamChecker) Name() string {
+	return CheckerNamespace
+}
+
+func (c NamespaceTeamChecker) Check(request Request, state *State) *Response {
+	if !request.WarnNamespaceTeam || request.SubmittedResource == nil || len(request.Namespace) == 0 {
+		return nil
+	}
+
+	namespace, err := request.NamespaceProvider(request.Namespace)
+	if err != nil {
+		return nil
+	}
+
+	namespaceTeam := namespace.GetLabels()["team"]
+	if len(namespaceTeam) > 0 && !sameTeam(request, namespaceTeam, state.Team) {
+		state.Warn(WarningTeamDiffersFromNamespace, state.Team.ID, request.Namespace, namespaceTeam)
+	}
+
+	return nil
+}
+
+// DeniedKindsChecker denies members and service users of a team from managing resource kinds the team is restricted from.
+// Users without access to the team are left for the following checkers to deny.
+type DeniedKindsChecker struct{}
+
+func (c DeniedKindsChecker) Name() string {
+	return CheckerDeniedKinds
+}
+
+func (c DeniedKindsChecker) Check(request Request, state *State) *Response {
+	for _, team := range []azure.Team{state.ExistingTeam, state.Team} {
+		if !kindDenied(request, team) {
+			continue
+		}
+		isServiceUser, _ := serviceUserAccess(request, team.ID)
+		if isMember(request, team) || isServiceUser {
+			return &Response{Allowed: false, Code: CodeTeamMayNotManageKind, Reason: fmt.Sprintf(ErrorTeamMayNotManageKind, team.ID, request.Kind)}
+		}
+	}
+	return nil
+}
+
+// MembershipChecker allows the request if the user is a member of the team specified on the submitted resource.
+type MembershipChecker struct{}
+
+func (c MembershipChecker) Name() string {
+	return CheckerMembership
+}
+
+func (c MembershipChecker) Check(request Request, state *State) *Response {
+	if !state.Team.Valid() || !isMember(request, state.Team) {
+		return nil
+	}
+	if request.ExistingResource != nil && len(state.ExistingLabel) == 0 {
+		return &Response{Allowed: true, Reason: SuccessUserMayAnnexateOrphanResource}
+	}
+	return &Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserBelongsToTeam, state.Team.ID)}
+}
+
+// ServiceUserChecker allows the request if the user matches one of the service user templates
+// for the team specified on the submitted resource.
+type ServiceUserChecker struct{}
+
+func (c ServiceUserChecker) Name() string {
+	return CheckerServiceUser
+}
+
+func (c ServiceUserChecker) Check(request Request, state *State) *Response {
+	isServiceUser, denied := serviceUserAccess(request, state.Team.ID)
+	if isServiceUser {
+		return &Response{Allowed: true, Reason: SuccessUserMatchesServiceUserTemplate}
+	}
+	return denied
+}
@@ -2,6 +2,7 @@ package tobac
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/nais/tobac/pkg/azure"
 	authenticationv1 "k8s.io/api/authentication/v1"
@@ -12,11 +13,49 @@ const ErrorNotTaggedWithTeamLabel = "object is not tagged with a team label"
 const ErrorTeamDoesNotExistInAzureAD = "team '%s' does not exist in Azure AD"
 const ErrorExistingTeamDoesNotExistInAzureAD = "team '%s' on existing resource does not exist in Azure AD"
 const ErrorUserHasNoAccessToTeam = "user '%s' has no access to team '%s'"
+const ErrorUserHasNoAccessToTeamContact = "user '%s' has no access to team '%s'; contact %s to request access"
+const ErrorOnBehalfOfTeamDoesNotExist = "team '%s' specified in annotation '%s' does not exist in Azure AD"
+const ErrorOnBehalfOfRequiresClusterAdmin = "only cluster administrators may set the annotation '%s'"
+const ErrorTeamLabelIsImmutable = "team label cannot be changed from '%s' to '%s'"
+const ErrorOperationRequiresClusterAdmin = "only cluster administrators may %s resources in the '%s' environment"
+const ErrorAnnexationNamespaceLookup = "while looking up namespace '%s': %s"
+const ErrorAnnexationOutsideTeamNamespace = "team '%s' may not annex resources in namespace '%s' owned by team '%s'"
+const ErrorTeamMayNotManageKind = "team '%s' is not permitted to manage %s resources"
+const ErrorTeamIsDeleted = "team '%s' has been deleted; no new resources can be created for it"
+const ErrorRateLimited = "user '%s' has exceeded the rate limit of %g requests per second; please try again later"
+const ErrorServiceUserRestricted = "service user '%s' is not permitted to %s %s resources in namespace '%s'"
+
+// Codes categorizing denials, e.g. for metrics. Several error messages may share a code.
+const CodeNotTaggedWithTeamLabel = "no_team_label"
+const CodeTeamDoesNotExist = "team_does_not_exist"
+const CodeUserHasNoAccessToTeam = "not_team_member"
+const CodeOnBehalfOf = "on_behalf_of"
+const CodeTeamLabelIsImmutable = "team_label_immutable"
+const CodeRequiresClusterAdmin = "requires_cluster_admin"
+const CodeAnnexation = "annexation"
+const CodeTeamMayNotManageKind = "denied_kind"
+const CodeTeamIsDeleted = "team_deleted"
+const CodeRateLimited = "rate_limited"
+const CodeServiceUserRestricted = "service_user_restricted"
+const CodeError = "error"
+
+const WarningTeamLabelIsAlias = "team '%s' has been renamed; please change the team label to '%s'"
+const WarningTeamDiffersFromNamespace = "team '%s' does not own namespace '%s', which belongs to team '%s'"
+const WarningTeamIsDeleted = "team '%s' has been deleted, and access through it will be revoked; please move this resource to another team"
+const WarningDegraded = "teams have not been synchronized for %s; this decision is based on outdated team information"
 
 const SuccessUserIsClusterAdmin = "user is cluster administrator through group '%s'"
+const SuccessUserIsClusterAdminOnBehalfOf = "user is cluster administrator through group '%s', acting on behalf of team '%s'"
 const SuccessUserBelongsToTeam = "user belongs to owner team '%s'"
 const SuccessUserMatchesServiceUserTemplate = "user matches service user template"
 const SuccessUserMayAnnexateOrphanResource = "resource did not have a team label set"
+const SuccessObjectDoesNotExist = "resource does not exist; there is nothing to protect"
+
+// AnnotationOnBehalfOf lets cluster administrators record which team they are acting on behalf of.
+const AnnotationOnBehalfOf = "tobac.nais.io/on-behalf-of"
+
+// Appended to every response message, so that users can quote the request ID when asking for support.
+const MessageRequestID = "%s (request ID: %s)"
 
 // KubernetesResource represents any Kubernetes resource with standard object metadata structures.
 type KubernetesResource struct {
@@ -24,22 +63,94 @@ type KubernetesResource struct {
 	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
 }
 
+// ServiceUserTemplate describes a Kubernetes user that is granted access to a team's resources.
+// The access can be restricted to certain kinds, operations and namespaces; empty lists mean no restriction.
+type ServiceUserTemplate struct {
+	Template   string
+	Kinds      []string
+	Operations []string
+	Namespaces []string
+}
+
+// EnvironmentOperation is an operation, such as DELETE, in a specific environment.
+// An empty environment matches any environment.
+type EnvironmentOperation struct {
+	Environment string
+	Operation   string
+}
+
+// ParseEnvironmentOperation parses a string on the form [ENVIRONMENT:]OPERATION.
+func ParseEnvironmentOperation(s string) (EnvironmentOperation, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		parts = []string{"", parts[0]}
+	}
+	if len(parts[1]) == 0 {
+		return EnvironmentOperation{}, fmt.Errorf("operation must be specified")
+	}
+	return EnvironmentOperation{
+		Environment: parts[0],
+		Operation:   strings.ToUpper(parts[1]),
+	}, nil
+}
+
+// Matches returns true if the request operation is performed in the specified environment.
+func (e EnvironmentOperation) Matches(request Request) bool {
+	if len(e.Environment) > 0 && e.Environment != request.Environment {
+		return false
+	}
+	return strings.EqualFold(e.Operation, request.Operation)
+}
+
 type Request struct {
 	UserInfo             authenticationv1.UserInfo
 	ExistingResource     metav1.Object
 	SubmittedResource    metav1.Object
+	Kind                 string
+	Operation            string
+	Namespace            string
+	Cluster              string
+	Environment          string
+	AdminOnlyOperations  []EnvironmentOperation
 	ClusterAdmins        []string
-	ServiceUserTemplates []string
+	ServiceUserTemplates []ServiceUserTemplate
+	GroupMapping         GroupMapping
+	DeniedKinds          DeniedKinds
+	ImmutableTeamLabel   bool
+	RestrictAnnexation   bool
+	WarnNamespaceTeam    bool
 	TeamProvider         TeamProvider
+	NamespaceProvider    NamespaceProvider
+	MembershipLookup     MembershipLookup
+	LastManager          string // field manager that most recently applied or updated the existing resource, if known
 }
 
 type Response struct {
-	Allowed bool
-	Reason  string
+	Allowed    bool
+	Reason     string
+	Code       string // category of the denial, if denied
+	Team       string // team the request was decided for, if any
+	OnBehalfOf string
+	Warnings   []string
 }
 
 type TeamProvider func(string) azure.Team
 
+// DeniedKinds maps team IDs to the resource kinds their members may not manage,
+// in addition to the restrictions known by the team provider.
+type DeniedKinds map[string][]string
+
+// MembershipLookup checks team membership against the team backend, for users whose
+// membership cannot be determined from their groups.
+type MembershipLookup func(username string, team azure.Team) bool
+
+// NamespaceProvider returns the namespace with the specified name.
+type NamespaceProvider func(string) (metav1.Object, error)
+
+// GroupMapping maps user groups to the team IDs their members belong to,
+// in addition to the memberships known by the team provider.
+type GroupMapping map[string][]string
+
 func stringInSlice(slice []string, str string) bool {
 	for _, s := range slice {
 		if str == s {
@@ -49,100 +160,143 @@ func stringInSlice(slice []string, str string) bool {
 	return false
 }
 
-// Check if a user is in the service user access list.
-func hasServiceUserAccess(username, teamID string, templates []string) bool {
-	for _, template := range templates {
-		allowedUser := fmt.Sprintf(template, teamID, teamID)
-		if username == allowedUser {
+// Check if a user is a member of the team, either through the team's group, or through a group mapping.
+func isMember(request Request, team azure.Team) bool {
+	if len(team.AzureUUID) > 0 && stringInSlice(request.UserInfo.Groups, team.AzureUUID) {
+		return true
+	}
+	if stringInSlice(team.Members, request.UserInfo.Username) {
+		return true
+	}
+	for _, group := range request.UserInfo.Groups {
+		if stringInSlice(team.Groups, group) || stringInSliceFold(request.GroupMapping[group], team.ID) {
 			return true
 		}
 	}
+	if request.MembershipLookup != nil && team.Valid() {
+		return request.MembershipLookup(request.UserInfo.Username, team)
+	}
 	return false
 }
 
-func ClusterAdminResponse(request Request) *Response {
-	for _, userGroup := range request.UserInfo.Groups {
-		for _, adminGroup := range request.ClusterAdmins {
-			if userGroup == adminGroup {
-				return &Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserIsClusterAdmin, adminGroup)}
-			}
-		}
+// Check if a team is denied from managing the resource kind in the request.
+func kindDenied(request Request, team azure.Team) bool {
+	if !team.Valid() || len(request.Kind) == 0 {
+		return false
 	}
-
-	return nil
+	return stringInSliceFold(team.DeniedKinds, request.Kind) || stringInSliceFold(request.DeniedKinds[team.ID], request.Kind)
 }
 
-func Allowed(request Request) Response {
-	var team azure.Team
-	var teamID string
-	var existingLabel string
-
-	// Allow if user is a cluster administrator
-	if response := ClusterAdminResponse(request); response != nil {
-		return *response
+func stringInSliceFold(slice []string, str string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(str, s) {
+			return true
+		}
 	}
+	return false
+}
 
-	if request.SubmittedResource != nil {
-		// Deny if object is not tagged with a team label.
-		teamID = request.SubmittedResource.GetLabels()["team"]
-		if len(teamID) == 0 {
-			return Response{Allowed: false, Reason: ErrorNotTaggedWithTeamLabel}
+// ParseServiceUserTemplate parses a service user template on the form
+// TEMPLATE[;kinds=KIND|KIND...][;operations=OPERATION|OPERATION...][;namespaces=NAMESPACE|NAMESPACE...].
+func ParseServiceUserTemplate(s string) (ServiceUserTemplate, error) {
+	parts := strings.Split(s, ";")
+	template := ServiceUserTemplate{
+		Template: parts[0],
+	}
+	if len(template.Template) == 0 {
+		return template, fmt.Errorf("service user template is empty")
+	}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return template, fmt.Errorf("restriction '%s' must be on the form key=value", part)
 		}
-
-		// Deny if specified team does not exist
-		team = request.TeamProvider(teamID)
-		if !team.Valid() {
-			return Response{Allowed: false, Reason: fmt.Sprintf(ErrorTeamDoesNotExistInAzureAD, teamID)}
+		values := strings.Split(kv[1], "|")
+		switch kv[0] {
+		case "kinds":
+			template.Kinds = values
+		case "operations":
+			template.Operations = values
+		case "namespaces":
+			template.Namespaces = values
+		default:
+			return template, fmt.Errorf("unknown restriction '%s'", kv[0])
 		}
 	}
+	return template, nil
+}
 
-	// This is an update situation. We must check if the user has access to modify the original resource.
-	if request.ExistingResource != nil {
-		existingLabel = request.ExistingResource.GetLabels()["team"]
-
-		// If the existing resource does not have a team label, skip permission checks.
-		if len(existingLabel) > 0 {
-
-			// Deny if existing team does not exist.
-			existingTeam := request.TeamProvider(existingLabel)
-			if !existingTeam.Valid() {
-				return Response{Allowed: false, Reason: fmt.Sprintf(ErrorExistingTeamDoesNotExistInAzureAD, existingLabel)}
-			}
+// Matches returns true if the username is the service user of the specified team.
+func (t ServiceUserTemplate) Matches(username, teamID string) bool {
+	return username == fmt.Sprintf(t.Template, teamID, teamID)
+}
 
-			// If user doesn't belong to the correct team, nor is in the service account access list, deny access.
-			serviceUserAccess := hasServiceUserAccess(request.UserInfo.Username, existingTeam.ID, request.ServiceUserTemplates)
-			if !stringInSlice(request.UserInfo.Groups, existingTeam.AzureUUID) && !serviceUserAccess {
-				return Response{Allowed: false, Reason: fmt.Sprintf(ErrorUserHasNoAccessToTeam, request.UserInfo.Username, existingTeam.ID)}
-			}
+// Permits returns true if the template restrictions allow the requested operation.
+func (t ServiceUserTemplate) Permits(request Request) bool {
+	if len(t.Kinds) > 0 && !stringInSliceFold(t.Kinds, request.Kind) {
+		return false
+	}
+	if len(t.Operations) > 0 && !stringInSliceFold(t.Operations, request.Operation) {
+		return false
+	}
+	if len(t.Namespaces) > 0 && !stringInSlice(t.Namespaces, request.Namespace) {
+		return false
+	}
+	return true
+}
 
-			// Allow deletes here, since there is no new resource to check
-			if request.SubmittedResource == nil {
-				if serviceUserAccess {
-					return Response{Allowed: true, Reason: SuccessUserMatchesServiceUserTemplate}
-				}
-				return Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserBelongsToTeam, existingLabel)}
-			}
+// Find the service user templates matching the requesting user.
+func matchingServiceUserTemplates(request Request, teamID string) []ServiceUserTemplate {
+	templates := make([]ServiceUserTemplate, 0)
+	for _, template := range request.ServiceUserTemplates {
+		if template.Matches(request.UserInfo.Username, teamID) {
+			templates = append(templates, template)
 		}
+	}
+	return templates
+}
 
-		// Allow deletes here, since there is no new resource to check
-		if request.SubmittedResource == nil {
-			return Response{Allowed: true, Reason: SuccessUserMayAnnexateOrphanResource}
+// Check if a user is in the service user access list, and returns a denial if the
+// user matches a service user template that does not permit this request.
+func serviceUserAccess(request Request, teamID string) (bool, *Response) {
+	templates := matchingServiceUserTemplates(request, teamID)
+	for _, template := range templates {
+		if template.Permits(request) {
+			return true, nil
 		}
 	}
+	if len(templates) > 0 {
+		return false, &Response{Allowed: false, Code: CodeServiceUserRestricted, Reason: fmt.Sprintf(ErrorServiceUserRestricted, request.UserInfo.Username, strings.ToLower(request.Operation), request.Kind, request.Namespace)}
+	}
+	return false, nil
+}
 
-	// Finally, allow if user exists in the specified team
-	if stringInSlice(request.UserInfo.Groups, team.AzureUUID) {
-		if request.ExistingResource != nil && len(existingLabel) == 0 {
-			return Response{Allowed: true, Reason: SuccessUserMayAnnexateOrphanResource}
+// Returns the group granting cluster administrator access to the user, if any.
+func adminGroup(request Request) string {
+	for _, userGroup := range request.UserInfo.Groups {
+		for _, adminGroup := range request.ClusterAdmins {
+			if userGroup == adminGroup {
+				return adminGroup
+			}
 		}
-		return Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserBelongsToTeam, team.ID)}
 	}
+	return ""
+}
 
-	// If user does not exist in the specified team, try to match against service user templates.
-	if hasServiceUserAccess(request.UserInfo.Username, team.ID, request.ServiceUserTemplates) {
-		return Response{Allowed: true, Reason: SuccessUserMatchesServiceUserTemplate}
+func ClusterAdminResponse(request Request) *Response {
+	group := adminGroup(request)
+	if len(group) == 0 {
+		return nil
 	}
+	return &Response{Allowed: true, Reason: fmt.Sprintf(SuccessUserIsClusterAdmin, group)}
+}
+
+// Allowed evaluates the request against the default decision chain.
+func Allowed(request Request) Response {
+	return defaultChain.Allowed(request)
+}
 
-	// default deny
-	return Response{Allowed: false, Reason: fmt.Sprintf(ErrorUserHasNoAccessToTeam, request.UserInfo.Username, teamID)}
+// Explain evaluates the request against the default decision chain, describing every step.
+func Explain(request Request) Explanation {
+	return defaultChain.Explain(request)
 }
@@ -3,6 +3,7 @@ package tobac_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/nais/tobac/pkg/azure"
 	"github.com/nais/tobac/pkg/tobac"
@@ -15,8 +16,18 @@ var clusterAdmins = []string{
 	"cluster-admin",
 }
 
-var serviceUserTemplates = []string{
-	"system:serviceaccounts:%s:serviceuser-%s",
+var serviceUserTemplates = []tobac.ServiceUserTemplate{
+	{
+		Template: "system:serviceaccounts:%s:serviceuser-%s",
+	},
+}
+
+var restrictedServiceUserTemplates = []tobac.ServiceUserTemplate{
+	{
+		Template:   "system:serviceaccounts:%s:deployer-%s",
+		Kinds:      []string{"Deployment"},
+		Operations: []string{"CREATE", "UPDATE"},
+	},
 }
 
 var emptyResource = &tobac.KubernetesResource{}
@@ -75,6 +86,7 @@ func TestRequireTeamLabel(t *testing.T) {
 	)
 	assert.False(t, response.Allowed)
 	assert.Equal(t, tobac.ErrorNotTaggedWithTeamLabel, response.Reason)
+	assert.Equal(t, tobac.CodeNotTaggedWithTeamLabel, response.Code)
 }
 
 func TestRequireTeamExists(t *testing.T) {
@@ -89,6 +101,7 @@ func TestRequireTeamExists(t *testing.T) {
 	)
 	assert.False(t, response.Allowed)
 	assert.Equal(t, fmt.Sprintf(tobac.ErrorTeamDoesNotExistInAzureAD, "foo"), response.Reason)
+	assert.Equal(t, tobac.CodeTeamDoesNotExist, response.Code)
 }
 
 func TestRequireExistingTeamExists(t *testing.T) {
@@ -253,6 +266,87 @@ func TestAnnexationOfUnlabeledResource(t *testing.T) {
 	assert.Equal(t, tobac.SuccessUserMayAnnexateOrphanResource, response.Reason)
 }
 
+func namespaceProvider(name string) (metav1.Object, error) {
+	if name == "does-not-exist" {
+		return nil, fmt.Errorf("not found")
+	}
+	return resourceWithTeam(name), nil
+}
+
+func TestRestrictedAnnexationInTeamNamespace(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			Namespace:            "foo",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			RestrictAnnexation:   true,
+			TeamProvider:         mockedTeamProvider,
+			NamespaceProvider:    namespaceProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+			ExistingResource:     emptyResource,
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, tobac.SuccessUserMayAnnexateOrphanResource, response.Reason)
+}
+
+func TestRestrictedAnnexationInOtherTeamNamespace(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			Namespace:            "baz",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			RestrictAnnexation:   true,
+			TeamProvider:         mockedTeamProvider,
+			NamespaceProvider:    namespaceProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+			ExistingResource:     emptyResource,
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorAnnexationOutsideTeamNamespace, "foo", "baz", "baz"), response.Reason)
+}
+
+func TestRestrictedAnnexationInAliasedTeamNamespace(t *testing.T) {
+	teamProvider := func(team string) azure.Team {
+		if team == "old-foo" {
+			team = "foo"
+		}
+		return mockedTeamProvider(team)
+	}
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			Namespace:            "old-foo",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			RestrictAnnexation:   true,
+			TeamProvider:         teamProvider,
+			NamespaceProvider:    namespaceProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+			ExistingResource:     emptyResource,
+		},
+	)
+	assert.True(t, response.Allowed, "the namespace team label is resolved through the team provider")
+}
+
 func TestAnnexationOfLabeledResource(t *testing.T) {
 	response := tobac.Allowed(
 		tobac.Request{
@@ -292,3 +386,625 @@ func TestMoveResourceToNewTeam(t *testing.T) {
 	)
 	assert.True(t, response.Allowed)
 }
+
+func TestMoveResourceToNewTeamWithImmutableLabel(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"old-team",
+					"new-team",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			ImmutableTeamLabel:   true,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("new-team"),
+			ExistingResource:     resourceWithTeam("old-team"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorTeamLabelIsImmutable, "old-team", "new-team"), response.Reason)
+}
+
+func TestAnnexationWithImmutableLabel(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			ImmutableTeamLabel:   true,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+			ExistingResource:     emptyResource,
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, tobac.SuccessUserMayAnnexateOrphanResource, response.Reason)
+}
+
+func TestRestrictedServiceUserAllowed(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "system:serviceaccounts:foo:deployer-foo",
+				Groups:   []string{},
+			},
+			Kind:                 "Deployment",
+			Operation:            "CREATE",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: restrictedServiceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, tobac.SuccessUserMatchesServiceUserTemplate, response.Reason)
+}
+
+func TestRestrictedServiceUserDeniedKind(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "system:serviceaccounts:foo:deployer-foo",
+				Groups:   []string{},
+			},
+			Kind:                 "Secret",
+			Operation:            "CREATE",
+			Namespace:            "default",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: restrictedServiceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorServiceUserRestricted, "system:serviceaccounts:foo:deployer-foo", "create", "Secret", "default"), response.Reason)
+}
+
+func TestRestrictedServiceUserDeniedDelete(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "system:serviceaccounts:foo:deployer-foo",
+				Groups:   []string{},
+			},
+			Kind:                 "Deployment",
+			Operation:            "DELETE",
+			Namespace:            "default",
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: restrictedServiceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			ExistingResource:     resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorServiceUserRestricted, "system:serviceaccounts:foo:deployer-foo", "delete", "Deployment", "default"), response.Reason)
+}
+
+func TestParseServiceUserTemplate(t *testing.T) {
+	template, err := tobac.ParseServiceUserTemplate("system:serviceaccount:%s:deployer-%s;kinds=Deployment|Service;operations=CREATE;namespaces=default")
+	assert.NoError(t, err)
+	assert.Equal(t, tobac.ServiceUserTemplate{
+		Template:   "system:serviceaccount:%s:deployer-%s",
+		Kinds:      []string{"Deployment", "Service"},
+		Operations: []string{"CREATE"},
+		Namespaces: []string{"default"},
+	}, template)
+
+	_, err = tobac.ParseServiceUserTemplate("system:serviceaccount:%s:deployer-%s;colors=blue")
+	assert.Error(t, err)
+}
+
+type denyAllChecker struct{}
+
+func (c denyAllChecker) Name() string {
+	return "deny-all"
+}
+
+func (c denyAllChecker) Check(request tobac.Request, state *tobac.State) *tobac.Response {
+	return &tobac.Response{Allowed: false, Reason: "denied by " + state.Team.ID}
+}
+
+func TestChainInsertBefore(t *testing.T) {
+	chain := tobac.DefaultChain()
+	err := chain.InsertBefore(tobac.CheckerMembership, denyAllChecker{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		tobac.CheckerClusterAdmin,
+		tobac.CheckerOnBehalfOf,
+		tobac.CheckerAdminOnly,
+		tobac.CheckerTeamLabel,
+		tobac.CheckerDeletedTeam,
+		tobac.CheckerExistingTeam,
+		tobac.CheckerImmutable,
+		tobac.CheckerAnnexation,
+		tobac.CheckerNamespace,
+		tobac.CheckerDeniedKinds,
+		"deny-all",
+		tobac.CheckerMembership,
+		tobac.CheckerServiceUser,
+	}, chain.Names())
+
+	response := chain.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "denied by foo", response.Reason)
+	assert.Equal(t, "deny-all", response.Code, "denials without a code are attributed to the checker")
+}
+
+func TestChainInsertUnknown(t *testing.T) {
+	chain := tobac.DefaultChain()
+	assert.Error(t, chain.InsertAfter("does-not-exist", denyAllChecker{}))
+	assert.Error(t, chain.Remove("does-not-exist"))
+	_, err := chain.Select(tobac.CheckerClusterAdmin, "does-not-exist")
+	assert.Error(t, err)
+}
+
+func TestChainSelectKeepsOrder(t *testing.T) {
+	chain, err := tobac.DefaultChain().Select(tobac.CheckerMembership, tobac.CheckerTeamLabel)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{tobac.CheckerTeamLabel, tobac.CheckerMembership}, chain.Names())
+
+	response := chain.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   clusterAdmins,
+			},
+			ClusterAdmins:     clusterAdmins,
+			TeamProvider:      mockedTeamProvider,
+			SubmittedResource: resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed, "cluster administrators are not allowed without the cluster admin checker")
+}
+
+func TestChainWithout(t *testing.T) {
+	names := tobac.DefaultChain().Names()
+	chain := tobac.DefaultChain().Without(tobac.CheckerClusterAdmin, tobac.CheckerServiceUser, "unknown")
+	assert.Equal(t, names[1:len(names)-1], chain.Names())
+}
+
+func TestAllowIfUserGroupIsMappedToTeam(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"legacy-group",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			GroupMapping: tobac.GroupMapping{
+				"legacy-group": []string{"foo"},
+			},
+			TeamProvider:      mockedTeamProvider,
+			SubmittedResource: resourceWithTeam("foo"),
+			ExistingResource:  resourceWithTeam("foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.SuccessUserBelongsToTeam, "foo"), response.Reason)
+}
+
+func resourceWithTeamOnBehalfOf(team, onBehalfOf string) *tobac.KubernetesResource {
+	resource := resourceWithTeam(team)
+	resource.Annotations = map[string]string{
+		tobac.AnnotationOnBehalfOf: onBehalfOf,
+	}
+	return resource
+}
+
+func TestClusterAdminOnBehalfOfTeam(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "i-dont-care",
+				Groups: []string{
+					"cluster-admin",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeamOnBehalfOf("foo", "foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, "foo", response.OnBehalfOf)
+	assert.Equal(t, fmt.Sprintf(tobac.SuccessUserIsClusterAdminOnBehalfOf, "cluster-admin", "foo"), response.Reason)
+}
+
+func TestClusterAdminOnBehalfOfNonExistingTeam(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "i-dont-care",
+				Groups: []string{
+					"cluster-admin",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeamOnBehalfOf("foo", "does-not-exist"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorOnBehalfOfTeamDoesNotExist, "does-not-exist", tobac.AnnotationOnBehalfOf), response.Reason)
+}
+
+func TestOnBehalfOfRequiresClusterAdmin(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeamOnBehalfOf("foo", "foo"),
+			ExistingResource:     resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorOnBehalfOfRequiresClusterAdmin, tobac.AnnotationOnBehalfOf), response.Reason)
+}
+
+func TestOnBehalfOfUnchangedByTeamMember(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeamOnBehalfOf("foo", "foo"),
+			ExistingResource:     resourceWithTeamOnBehalfOf("foo", "foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+}
+
+func TestAdminOnlyOperationInEnvironment(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+			Groups: []string{
+				"foo",
+			},
+		},
+		Operation:   "DELETE",
+		Environment: "prod",
+		AdminOnlyOperations: []tobac.EnvironmentOperation{
+			{Environment: "prod", Operation: "DELETE"},
+		},
+		ClusterAdmins:        clusterAdmins,
+		ServiceUserTemplates: serviceUserTemplates,
+		TeamProvider:         mockedTeamProvider,
+		ExistingResource:     resourceWithTeam("foo"),
+	}
+
+	response := tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorOperationRequiresClusterAdmin, "delete", "prod"), response.Reason)
+
+	request.Environment = "dev"
+	response = tobac.Allowed(request)
+	assert.True(t, response.Allowed)
+
+	request.Environment = "prod"
+	request.UserInfo.Groups = append(request.UserInfo.Groups, "cluster-admin")
+	response = tobac.Allowed(request)
+	assert.True(t, response.Allowed)
+}
+
+func TestTeamDeniedKind(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+			Groups: []string{
+				"foo",
+			},
+		},
+		Kind: "ClusterRole",
+		DeniedKinds: tobac.DeniedKinds{
+			"foo": []string{"clusterrole", "CustomResourceDefinition"},
+		},
+		ClusterAdmins:        clusterAdmins,
+		ServiceUserTemplates: serviceUserTemplates,
+		TeamProvider:         mockedTeamProvider,
+		SubmittedResource:    resourceWithTeam("foo"),
+	}
+
+	response := tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorTeamMayNotManageKind, "foo", "ClusterRole"), response.Reason)
+
+	request.Kind = "Deployment"
+	response = tobac.Allowed(request)
+	assert.True(t, response.Allowed)
+}
+
+func TestTeamDeniedKindDelete(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+			Groups: []string{
+				"foo",
+			},
+		},
+		Kind:      "CustomResourceDefinition",
+		Operation: "DELETE",
+		DeniedKinds: tobac.DeniedKinds{
+			"foo": []string{"clusterrole", "CustomResourceDefinition"},
+		},
+		ClusterAdmins:        clusterAdmins,
+		ServiceUserTemplates: serviceUserTemplates,
+		TeamProvider:         mockedTeamProvider,
+		ExistingResource:     resourceWithTeam("foo"),
+	}
+
+	response := tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorTeamMayNotManageKind, "foo", "CustomResourceDefinition"), response.Reason)
+
+	request.Kind = "Deployment"
+	response = tobac.Allowed(request)
+	assert.True(t, response.Allowed)
+}
+
+func TestTeamDeniedKindNonMember(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   []string{},
+			},
+			Kind: "ClusterRole",
+			DeniedKinds: tobac.DeniedKinds{
+				"foo": []string{"ClusterRole"},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         mockedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorUserHasNoAccessToTeam, "bar", "foo"), response.Reason)
+}
+
+func aliasedTeamProvider(team string) azure.Team {
+	if team == "old-foo" {
+		team = "foo"
+	}
+	return mockedTeamProvider(team)
+}
+
+func TestTeamLabelIsAlias(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider:         aliasedTeamProvider,
+			SubmittedResource:    resourceWithTeam("old-foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.SuccessUserBelongsToTeam, "foo"), response.Reason)
+	assert.Equal(t, []string{fmt.Sprintf(tobac.WarningTeamLabelIsAlias, "old-foo", "foo")}, response.Warnings)
+}
+
+func TestRenameAliasWithImmutableLabel(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups: []string{
+					"foo",
+				},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			ImmutableTeamLabel:   true,
+			TeamProvider:         aliasedTeamProvider,
+			SubmittedResource:    resourceWithTeam("foo"),
+			ExistingResource:     resourceWithTeam("old-foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Empty(t, response.Warnings)
+}
+
+func TestAllowIfMembershipLookupSucceeds(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+			Groups:   []string{},
+		},
+		ClusterAdmins:        clusterAdmins,
+		ServiceUserTemplates: serviceUserTemplates,
+		TeamProvider:         mockedTeamProvider,
+		MembershipLookup: func(username string, team azure.Team) bool {
+			return username == "bar" && team.ID == "foo"
+		},
+		SubmittedResource: resourceWithTeam("foo"),
+		ExistingResource:  resourceWithTeam("foo"),
+	}
+
+	response := tobac.Allowed(request)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.SuccessUserBelongsToTeam, "foo"), response.Reason)
+
+	request.UserInfo.Username = "baz"
+	response = tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+}
+
+func TestDenialIncludesTeamContact(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   []string{},
+			},
+			ClusterAdmins:        clusterAdmins,
+			ServiceUserTemplates: serviceUserTemplates,
+			TeamProvider: func(team string) azure.Team {
+				return azure.Team{ID: team, AzureUUID: team, Contact: "#team-foo"}
+			},
+			SubmittedResource: resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorUserHasNoAccessToTeamContact, "bar", "foo", "#team-foo"), response.Reason)
+	assert.Equal(t, tobac.CodeUserHasNoAccessToTeam, response.Code)
+}
+
+func deletedTeamProvider(team string) azure.Team {
+	return azure.Team{ID: team, AzureUUID: team, Deleted: time.Now()}
+}
+
+func TestDeletedTeamDeniesCreate(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   []string{"foo"},
+			},
+			Operation:         "CREATE",
+			TeamProvider:      deletedTeamProvider,
+			SubmittedResource: resourceWithTeam("foo"),
+		},
+	)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, fmt.Sprintf(tobac.ErrorTeamIsDeleted, "foo"), response.Reason)
+}
+
+func TestDeletedTeamAllowsUpdateWithWarning(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   []string{"foo"},
+			},
+			Operation:         "UPDATE",
+			TeamProvider:      deletedTeamProvider,
+			SubmittedResource: resourceWithTeam("foo"),
+			ExistingResource:  resourceWithTeam("foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{fmt.Sprintf(tobac.WarningTeamIsDeleted, "foo")}, response.Warnings)
+}
+
+func TestWarnNamespaceOwnedByOtherTeam(t *testing.T) {
+	response := tobac.Allowed(
+		tobac.Request{
+			UserInfo: authenticationv1.UserInfo{
+				Username: "bar",
+				Groups:   []string{"foo"},
+			},
+			Namespace:         "baz",
+			WarnNamespaceTeam: true,
+			TeamProvider:      mockedTeamProvider,
+			NamespaceProvider: namespaceProvider,
+			SubmittedResource: resourceWithTeam("foo"),
+		},
+	)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{fmt.Sprintf(tobac.WarningTeamDiffersFromNamespace, "foo", "baz", "baz")}, response.Warnings)
+}
+
+func TestResponseNamesCanonicalTeam(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+		},
+		TeamProvider:      aliasedTeamProvider,
+		SubmittedResource: resourceWithTeam("old-foo"),
+	}
+
+	response := tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "foo", response.Team)
+
+	request.SubmittedResource = resourceWithTeam("does-not-exist")
+	request.TeamProvider = mockedTeamProvider
+	response = tobac.Allowed(request)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "does-not-exist", response.Team)
+}
+
+func TestExplainRecordsEveryStep(t *testing.T) {
+	request := tobac.Request{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "bar",
+			Groups:   []string{"foo"},
+		},
+		Namespace:         "baz",
+		WarnNamespaceTeam: true,
+		TeamProvider:      mockedTeamProvider,
+		NamespaceProvider: namespaceProvider,
+		SubmittedResource: resourceWithTeam("foo"),
+	}
+
+	explanation := tobac.Explain(request)
+	assert.Equal(t, tobac.Allowed(request), explanation.Response)
+	assert.Equal(t, "foo", explanation.State.Team.ID)
+
+	decided := 0
+	for _, step := range explanation.Steps {
+		switch step.Checker {
+		case tobac.CheckerClusterAdmin:
+			assert.True(t, step.Evaluated)
+			assert.False(t, step.Decided)
+		case tobac.CheckerNamespace:
+			assert.Equal(t, []string{fmt.Sprintf(tobac.WarningTeamDiffersFromNamespace, "foo", "baz", "baz")}, step.Warnings)
+		case tobac.CheckerMembership:
+			assert.True(t, step.Decided)
+			assert.True(t, step.Allowed)
+		case tobac.CheckerServiceUser:
+			assert.False(t, step.Evaluated)
+		}
+		if step.Decided {
+			decided++
+		}
+	}
+	assert.Equal(t, 1, decided)
+}
@@ -0,0 +1,206 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Spans are sent in batches of at most this size, or when the flush interval has passed.
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+	// Spans ended while this many are waiting to be sent are dropped.
+	queueSize = 4096
+	// Time allowed for sending remaining spans when the exporter stops.
+	shutdownTimeout = 5 * time.Second
+)
+
+// Exporter sends spans to an OpenTelemetry collector using OTLP over HTTP with JSON encoding.
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+type Exporter struct {
+	url      string
+	client   *http.Client
+	resource []attribute
+	spans    chan *Span
+}
+
+// Enable sends spans to the OTLP/HTTP endpoint of a collector, e.g. 'http://otel-collector:4318'.
+// The resource attributes identify this instance, e.g. 'service.name'.
+func Enable(endpoint string, resource map[string]string) *Exporter {
+	exporter = &Exporter{
+		url:      strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		resource: attributes(resource),
+		spans:    make(chan *Span, queueSize),
+	}
+	return exporter
+}
+
+func (e *Exporter) export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		log.Debugf("tracing: dropping span '%s', export queue is full", span.name)
+	}
+}
+
+// Run sends spans in batches until the context is done, then sends the remaining spans.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.send(ctx, batch)
+		if err != nil {
+			log.Warnf("tracing: while exporting %d spans: %s", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			for {
+				select {
+				case span := <-e.spans:
+					batch = append(batch, span)
+					if len(batch) == batchSize {
+						flush(shutdown)
+					}
+				default:
+					flush(shutdown)
+					return
+				}
+			}
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              Kind        `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type resourceSpans struct {
+	Resource struct {
+		Attributes []attribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type request struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+// Attributes sorted by key.
+func attributes(values map[string]string) []attribute {
+	list := make([]attribute, 0, len(values))
+	for key, value := range values {
+		a := attribute{Key: key}
+		a.Value.StringValue = value
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+func encode(s *Span) span {
+	encoded := span{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        attributes(s.attributes),
+	}
+	if s.parentID != [8]byte{} {
+		encoded.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if len(s.err) > 0 {
+		encoded.Status = &status{Code: 2, Message: s.err}
+	}
+	return encoded
+}
+
+func (e *Exporter) send(ctx context.Context, batch []*Span) error {
+	scope := scopeSpans{Spans: make([]span, 0, len(batch))}
+	scope.Scope.Name = "github.com/nais/tobac"
+	for _, s := range batch {
+		scope.Spans = append(scope.Spans, encode(s))
+	}
+	resource := resourceSpans{ScopeSpans: []scopeSpans{scope}}
+	resource.Resource.Attributes = e.resource
+
+	data, err := json.Marshal(request{ResourceSpans: []resourceSpans{resource}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
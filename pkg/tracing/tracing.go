@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Kind describes the role of a span, as defined by OpenTelemetry.
+type Kind int
+
+const (
+	Internal Kind = 1
+	Server   Kind = 2
+	Client   Kind = 3
+)
+
+// Span is a timed operation within a trace. A nil span is valid and records nothing,
+// so that callers need not check whether tracing is enabled.
+type Span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	sampled    bool
+	name       string
+	kind       Kind
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+}
+
+type contextKey struct{}
+
+// Receives ended spans. Nil disables tracing.
+var exporter *Exporter
+
+// Fraction of traces to record.
+var sampleRatio = 1.0
+
+// SetSampleRatio configures the fraction of new traces that are recorded. Traces continued from
+// a caller follow the caller's sampling decision.
+func SetSampleRatio(ratio float64) {
+	sampleRatio = ratio
+}
+
+// Start begins a span, as a child of the span in the context if there is one.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if exporter == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	if parent, ok := ctx.Value(contextKey{}).(*Span); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+		span.sampled = parent.sampled
+	} else {
+		rand.Read(span.traceID[:])
+		span.sampled = sample()
+	}
+	rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// Continue returns a context carrying the remote parent span from a W3C traceparent header,
+// e.g. '00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01'. Invalid headers are ignored.
+func Continue(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[3]) != 2 {
+		return ctx
+	}
+	parent := &Span{}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(parent.traceID) {
+		return ctx
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(parent.spanID) {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	copy(parent.traceID[:], traceID)
+	copy(parent.spanID[:], spanID)
+	parent.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, contextKey{}, parent)
+}
+
+func sample() bool {
+	if sampleRatio >= 1 {
+		return true
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<32))
+	if err != nil {
+		return false
+	}
+	return float64(n.Int64()) < sampleRatio*(1<<32)
+}
+
+// SetAttribute annotates the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = fmt.Sprint(value)
+}
+
+// SetError marks the span as failed, if the error is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End completes the span and queues it for export.
+func (s *Span) End() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.end = time.Now()
+	exporter.export(s)
+}
+
+// TraceID returns the hex encoded trace ID, or an empty string for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
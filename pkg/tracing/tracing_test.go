@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisabledTracingIsNoop(t *testing.T) {
+	exporter = nil
+	ctx, span := Start(context.Background(), "admission", Server)
+	assert.Nil(t, span)
+	span.SetAttribute("user", "user@example.com")
+	span.SetError(fmt.Errorf("failure"))
+	span.End()
+	assert.Equal(t, context.Background(), ctx)
+}
+
+func TestExport(t *testing.T) {
+	received := make(chan request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		payload := request{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer collector.Close()
+
+	Enable(collector.URL, map[string]string{"service.name": "tobac"})
+	defer func() {
+		exporter = nil
+	}()
+
+	ctx := Continue(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, parent := Start(ctx, "admission", Server)
+	_, child := Start(ctx, "get namespace", Client)
+	child.SetError(fmt.Errorf("not found"))
+	child.End()
+	parent.End()
+
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	exporter.Run(ctx)
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, "service.name", payload.ResourceSpans[0].Resource.Attributes[0].Key)
+		spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "get namespace", spans[0].Name)
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].TraceID)
+		assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+		assert.Equal(t, "not found", spans[0].Status.Message)
+		assert.Equal(t, "b7ad6b7169203331", spans[1].ParentSpanID)
+		assert.Nil(t, spans[1].Status)
+	case <-time.After(time.Second):
+		t.Fatal("no spans exported")
+	}
+}
+
+func TestUnsampledParentIsNotExported(t *testing.T) {
+	Enable("http://localhost:4318", nil)
+	defer func() {
+		exporter = nil
+	}()
+
+	ctx := Continue(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	_, span := Start(ctx, "admission", Server)
+	span.End()
+	assert.Len(t, exporter.spans, 0)
+}
@@ -1,4 +1,27 @@
 package version
 
+import (
+	"runtime"
+)
+
 var Revision = "local development version" // Git commit hash
 var Version = "0"                          // Numeric version
+var BuildDate = "unknown"                  // Time of build, in RFC 3339 format
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns information about the running build.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Revision:  Revision,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}